@@ -0,0 +1,171 @@
+package amdfw
+
+import (
+	"fmt"
+)
+
+// Translates between the addresses found in firmware structures and the bytes of an image.
+// Addresses at or above FlashMapping are MMIO addresses, everything below is a flash offset.
+// Relative addresses are offsets into the (possibly partial) image starting at BaseOffset.
+type AddressSpace struct {
+	// MMIO address of flash offset 0. 0 if every address is a flash offset.
+	FlashMapping uint32
+	// Flash offset of the first byte of the image
+	BaseOffset uint32
+	// Number of bytes of the image
+	Size uint32
+}
+
+func NewAddressSpace(flashMapping uint32, baseOffset uint32, imageBytes []byte) AddressSpace {
+	return AddressSpace{
+		FlashMapping: flashMapping,
+		BaseOffset:   baseOffset,
+		Size:         uint32(len(imageBytes)),
+	}
+}
+
+// Address space the image was parsed with
+func (image *Image) AddressSpace(imageBytes []byte) AddressSpace {
+	flashMapping := uint32(0)
+	if image.FlashMapping != nil {
+		flashMapping = *image.FlashMapping
+	}
+	return NewAddressSpace(flashMapping, image.BaseOffset, imageBytes)
+}
+
+func (space AddressSpace) IsMMIO(address uint32) bool {
+	return space.FlashMapping != 0 && address >= space.FlashMapping
+}
+
+// Converts an MMIO address to a flash offset. Flash offsets are returned as is.
+func (space AddressSpace) ToFlashOffset(address uint32) uint32 {
+	if space.IsMMIO(address) {
+		return address - space.FlashMapping
+	}
+	return address
+}
+
+// Converts a flash offset to an MMIO address. MMIO addresses are returned as is.
+func (space AddressSpace) ToMMIO(address uint32) uint32 {
+	if space.IsMMIO(address) {
+		return address
+	}
+	return address + space.FlashMapping
+}
+
+// Converts any address to an offset into the image bytes.
+// Returns false if the address is not part of the image.
+func (space AddressSpace) ToRelative(address uint32) (uint32, bool) {
+	offset := space.ToFlashOffset(address)
+	if offset < space.BaseOffset || offset-space.BaseOffset >= space.Size {
+		return 0, false
+	}
+	return offset - space.BaseOffset, true
+}
+
+// Converts an offset into the image bytes to a flash offset
+func (space AddressSpace) FromRelative(relative uint32) uint32 {
+	return relative + space.BaseOffset
+}
+
+// Whether size bytes at address are part of the image
+func (space AddressSpace) Contains(address uint32, size uint32) bool {
+	relative, ok := space.ToRelative(address)
+	return ok && uint64(relative)+uint64(size) <= uint64(space.Size)
+}
+
+// Expresses the flash offset in the same form as reference: MMIO if reference is MMIO, a flash offset otherwise
+func (space AddressSpace) Like(reference uint32, offset uint32) uint32 {
+	if space.IsMMIO(reference) {
+		return space.ToMMIO(offset)
+	}
+	return offset
+}
+
+// Resolves a pointer found in the image to the bytes it references
+func (space AddressSpace) Slice(imageBytes []byte, address uint32, size uint32) ([]byte, error) {
+	if !space.Contains(address, size) || int(space.Size) > len(imageBytes) {
+		return nil, fmt.Errorf("Address 0x%08X (0x%X bytes) is not part of the image", address, size)
+	}
+	relative, _ := space.ToRelative(address)
+	return imageBytes[relative : relative+size], nil
+}
+
+const (
+	// Flash offset or MMIO address
+	PhysicalAddressMode AddressMode = 0
+	// Offset from the start of the BIOS image, which is the start of the flash
+	BIOSRelativeAddressMode AddressMode = 1
+	// Offset from the directory header
+	DirectoryRelativeAddressMode AddressMode = 2
+	// Offset from the start of the slot containing the directory
+	SlotRelativeAddressMode AddressMode = 3
+)
+
+// Zen 2+ directories encode how the location of an entry is to be interpreted
+type AddressMode uint8
+
+func (mode AddressMode) String() string {
+	switch mode {
+	case PhysicalAddressMode:
+		return "physical"
+	case BIOSRelativeAddressMode:
+		return "BIOS relative"
+	case DirectoryRelativeAddressMode:
+		return "directory relative"
+	case SlotRelativeAddressMode:
+		return "slot relative"
+	default:
+		return fmt.Sprintf("unknown (%d)", uint8(mode))
+	}
+}
+
+// Bits 29-30 of the additional info word (Reserved) of the directory header
+func (header *DirectoryHeader) AddressMode() AddressMode {
+	return AddressMode(header.Reserved>>29) & 0x3
+}
+
+// Bits 62-63 of the 64bit location spanning Location and Reserved
+func (entry *DirectoryEntry) AddressMode() AddressMode {
+	if entry.IsValueEntry() {
+		return PhysicalAddressMode
+	}
+	return AddressMode(entry.Reserved>>30) & 0x3
+}
+
+// Mode of the entry, falling back to the mode of the directory header
+func (directory *Directory) EntryAddressMode(entry *DirectoryEntry) AddressMode {
+	if mode := entry.AddressMode(); mode != PhysicalAddressMode {
+		return mode
+	}
+	return directory.Header.AddressMode()
+}
+
+// Resolves the location of an entry to a flash offset
+func (directory *Directory) ResolveLocation(entry *DirectoryEntry, space AddressSpace) uint32 {
+	switch directory.EntryAddressMode(entry) {
+	case BIOSRelativeAddressMode:
+		return entry.Location
+	case DirectoryRelativeAddressMode:
+		return directory.Location + entry.Location
+	case SlotRelativeAddressMode:
+		return directory.SlotBase + entry.Location
+	default:
+		return space.ToFlashOffset(entry.Location)
+	}
+}
+
+// Points the entry to a flash offset in the address mode the entry already uses.
+// The mode bits are left untouched.
+func (directory *Directory) EncodeLocation(entry *DirectoryEntry, offset uint32, space AddressSpace) {
+	switch directory.EntryAddressMode(entry) {
+	case BIOSRelativeAddressMode:
+		entry.Location = offset
+	case DirectoryRelativeAddressMode:
+		entry.Location = offset - directory.Location
+	case SlotRelativeAddressMode:
+		entry.Location = offset - directory.SlotBase
+	default:
+		entry.Location = space.Like(entry.Location, offset)
+	}
+}
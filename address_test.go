@@ -0,0 +1,136 @@
+package amdfw
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestAddressSpace_Conversions(t *testing.T) {
+	space := AddressSpace{FlashMapping: DefaultFlashMapping, BaseOffset: 0xC00000, Size: 0x400000}
+
+	assert.True(t, space.IsMMIO(0xFFC01000))
+	assert.False(t, space.IsMMIO(0xC01000))
+	assert.Equal(t, uint32(0xC01000), space.ToFlashOffset(0xFFC01000))
+	assert.Equal(t, uint32(0xC01000), space.ToFlashOffset(0xC01000))
+	assert.Equal(t, uint32(0xFFC01000), space.ToMMIO(0xC01000))
+	assert.Equal(t, uint32(0xFFC01000), space.ToMMIO(0xFFC01000))
+	assert.Equal(t, uint32(0xFFC02000), space.Like(0xFF000000, 0xC02000))
+	assert.Equal(t, uint32(0xC02000), space.Like(0x0, 0xC02000))
+	assert.Equal(t, uint32(0xC00010), space.FromRelative(0x10))
+
+	relative, inImage := space.ToRelative(0xFFC01000)
+	assert.True(t, inImage)
+	assert.Equal(t, uint32(0x1000), relative)
+
+	relative, inImage = space.ToRelative(0xC01000)
+	assert.True(t, inImage)
+	assert.Equal(t, uint32(0x1000), relative)
+
+	_, inImage = space.ToRelative(0xFF101000)
+	assert.False(t, inImage)
+	_, inImage = space.ToRelative(0x1000000)
+	assert.False(t, inImage)
+}
+
+func TestAddressSpace_Unmapped(t *testing.T) {
+	space := AddressSpace{Size: 0x1000}
+
+	assert.False(t, space.IsMMIO(0xFF000000))
+	assert.Equal(t, uint32(0xFF000000), space.ToFlashOffset(0xFF000000))
+	assert.True(t, space.Contains(0x0, 0x1000))
+	assert.False(t, space.Contains(0x0, 0x1001))
+	assert.False(t, space.Contains(0x1000, 0x0))
+}
+
+func TestAddressSpace_Slice(t *testing.T) {
+	imageBytes := []byte{0x0, 0x1, 0x2, 0x3, 0x4, 0x5, 0x6, 0x7}
+	space := NewAddressSpace(DefaultFlashMapping, 0x100, imageBytes)
+
+	data, err := space.Slice(imageBytes, 0xFF000102, 4)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{0x2, 0x3, 0x4, 0x5}, data)
+
+	data, err = space.Slice(imageBytes, 0x106, 4)
+	assert.EqualError(t, err, "Address 0x00000106 (0x4 bytes) is not part of the image")
+	assert.Nil(t, data)
+}
+
+func TestAddressMode_Decode(t *testing.T) {
+	header := DirectoryHeader{Reserved: 0x40000000}
+	assert.Equal(t, DirectoryRelativeAddressMode, header.AddressMode())
+
+	entry := DirectoryEntry{Location: 0x1000, Reserved: 0x40000000}
+	assert.Equal(t, BIOSRelativeAddressMode, entry.AddressMode())
+
+	entry = DirectoryEntry{Location: 0x1000, Size: ValueEntrySize, Reserved: 0xC0000000}
+	assert.Equal(t, PhysicalAddressMode, entry.AddressMode())
+
+	assert.Equal(t, "slot relative", SlotRelativeAddressMode.String())
+}
+
+func TestDirectory_ResolveLocation(t *testing.T) {
+	space := AddressSpace{FlashMapping: DefaultFlashMapping, Size: 0x1000000}
+	directory := Directory{Location: 0x20000, SlotBase: 0x10000}
+
+	for _, test := range []struct {
+		entry    DirectoryEntry
+		expected uint32
+	}{
+		{DirectoryEntry{Location: 0xFF030000}, 0x30000},
+		{DirectoryEntry{Location: 0x30000}, 0x30000},
+		{DirectoryEntry{Location: 0x30000, Reserved: 0x40000000}, 0x30000},
+		{DirectoryEntry{Location: 0x400, Reserved: 0x80000000}, 0x20400},
+		{DirectoryEntry{Location: 0x400, Reserved: 0xC0000000}, 0x10400},
+	} {
+		assert.Equal(t, test.expected, directory.ResolveLocation(&test.entry, space))
+
+		// Encoding the resolved location again must not change the entry
+		encoded := test.entry
+		directory.EncodeLocation(&encoded, test.expected, space)
+		assert.Equal(t, test.entry, encoded)
+	}
+}
+
+func TestDirectory_ResolveLocationHeaderMode(t *testing.T) {
+	space := AddressSpace{Size: 0x100000}
+	directory := Directory{Header: DirectoryHeader{Reserved: 0x40000000}, Location: 0x20000}
+
+	entry := DirectoryEntry{Location: 0x400}
+	assert.Equal(t, DirectoryRelativeAddressMode, directory.EntryAddressMode(&entry))
+	assert.Equal(t, uint32(0x20400), directory.ResolveLocation(&entry, space))
+
+	// Entry bits take precedence over the header
+	entry.Reserved = 0x40000000
+	assert.Equal(t, uint32(0x400), directory.ResolveLocation(&entry, space))
+
+	directory.EncodeLocation(&entry, 0x30000, space)
+	assert.Equal(t, uint32(0x30000), entry.Location)
+	assert.Equal(t, uint32(0x40000000), entry.Reserved)
+}
+
+func TestParseDirectoryDirectoryRelative(t *testing.T) {
+	imageBytes := make([]byte, 0x10000)
+	space := NewAddressSpace(0, 0, imageBytes)
+	data := []byte{0x1, 0x2, 0x3, 0x4, 0x5, 0x6, 0x7, 0x8}
+
+	directory := Directory{
+		Header:   DirectoryHeader{Cookie: [4]byte{'$', 'P', 'S', 'P'}, TotalEntries: 1},
+		Location: 0x1000,
+		Entries: []Entry{{
+			DirectoryEntry: DirectoryEntry{Type: 0x3, Size: uint32(len(data)), Location: 0x400, Reserved: 0x80000000},
+			Raw:            data,
+		}},
+	}
+	directory.UpdateChecksum()
+	assert.Nil(t, directory.WriteInSpace(imageBytes, space))
+	assert.Equal(t, data, imageBytes[0x1400:0x1408])
+
+	parsed, err := ParseDirectoryInSpace(imageBytes, 0x1000, space)
+
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(0x400), parsed.Entries[0].DirectoryEntry.Location)
+	assert.Equal(t, uint32(0x80000000), parsed.Entries[0].DirectoryEntry.Reserved)
+	assert.Equal(t, data, parsed.Entries[0].Raw)
+	valid, _ := parsed.ValidateChecksum()
+	assert.True(t, valid)
+}
@@ -0,0 +1,187 @@
+package amdfw
+
+import (
+	"fmt"
+	"sort"
+)
+
+const DefaultAlignment = uint32(0x1000)
+
+type (
+	// Hands out erased and unreferenced regions of an image.
+	// Offsets passed in and returned are flash offsets.
+	Allocator struct {
+		Alignment     uint32
+		firmwareBytes []byte
+		space         AddressSpace
+		used          []allocation
+	}
+
+	// Relative to the image
+	allocation struct {
+		start uint32
+		end   uint32
+	}
+
+	entryKey struct {
+		offset uint32
+		size   uint32
+	}
+)
+
+// Creates an allocator treating every region of the image layout except erased gaps as used
+func NewAllocator(image *Image, firmwareBytes []byte, alignment uint32) *Allocator {
+	if alignment == 0 {
+		alignment = 1
+	}
+
+	allocator := Allocator{
+		Alignment:     alignment,
+		firmwareBytes: firmwareBytes,
+		space:         image.AddressSpace(firmwareBytes),
+	}
+
+	for _, region := range image.Layout(firmwareBytes) {
+		if region.Type != ErasedRegion {
+			allocator.Reserve(region.Start, region.Size)
+		}
+	}
+
+	return &allocator
+}
+
+// Marks a region as used
+func (allocator *Allocator) Reserve(start uint32, size uint32) {
+	relative, inImage := allocator.space.ToRelative(start)
+	if size == 0 || !inImage {
+		return
+	}
+
+	end := uint64(relative) + uint64(size)
+	if end > uint64(len(allocator.firmwareBytes)) {
+		end = uint64(len(allocator.firmwareBytes))
+	}
+
+	allocator.used = append(allocator.used, allocation{start: relative, end: uint32(end)})
+	sort.Slice(allocator.used, func(i, j int) bool {
+		return allocator.used[i].start < allocator.used[j].start
+	})
+}
+
+// Finds an aligned, erased and unused region of size bytes and reserves it.
+// The returned address is a flash offset.
+func (allocator *Allocator) Allocate(size uint32) (uint32, error) {
+	if size == 0 {
+		return 0, fmt.Errorf("Cannot allocate 0 bytes")
+	}
+
+	alignment := uint64(allocator.Alignment)
+	base := uint64(allocator.space.BaseOffset)
+	imageEnd := base + uint64(len(allocator.firmwareBytes))
+
+	// Alignment applies to flash offsets, not to offsets into a partial image
+	for start := (base + alignment - 1) / alignment * alignment; start+uint64(size) <= imageEnd; start += alignment {
+		relative := uint32(start - base)
+		end := relative + size
+
+		if blocking := allocator.overlapping(relative, end); blocking != nil {
+			// Continue behind the blocking region
+			next := (base + uint64(blocking.end) + alignment - 1) / alignment * alignment
+			start = next - alignment
+			continue
+		}
+
+		if erased, last := allocator.isErased(relative, end); !erased {
+			next := (base + uint64(last) + alignment) / alignment * alignment
+			start = next - alignment
+			continue
+		}
+
+		allocator.Reserve(uint32(start), size)
+		return uint32(start), nil
+	}
+
+	return 0, fmt.Errorf("No free space for 0x%X bytes", size)
+}
+
+func (allocator *Allocator) overlapping(start uint32, end uint32) *allocation {
+	for i := range allocator.used {
+		if allocator.used[i].start < end && start < allocator.used[i].end {
+			return &allocator.used[i]
+		}
+	}
+	return nil
+}
+
+// Returns whether the region is all 0xFF and otherwise the last offending offset
+func (allocator *Allocator) isErased(start uint32, end uint32) (bool, uint32) {
+	for address := end; address > start; address-- {
+		if allocator.firmwareBytes[address-1] != 0xFF {
+			return false, address - 1
+		}
+	}
+	return true, 0
+}
+
+// Moves every entry whose Raw outgrew its directory entry into free space.
+// All directory entries referencing the same blob are updated and their checksums refreshed.
+// The previous location is left untouched.
+func (image *Image) RelocateEntries(firmwareBytes []byte, alignment uint32) error {
+	space := image.AddressSpace(firmwareBytes)
+	allocator := NewAllocator(image, firmwareBytes, alignment)
+
+	grown := make(map[entryKey][]byte)
+	for _, rom := range image.Roms {
+		for _, directory := range rom.Directories {
+			for _, entry := range directory.Entries {
+				if entry.DirectoryEntry.IsValueEntry() || uint32(len(entry.Raw)) <= entry.DirectoryEntry.Size {
+					continue
+				}
+				key := entryKey{
+					offset: directory.ResolveLocation(&entry.DirectoryEntry, space),
+					size:   entry.DirectoryEntry.Size,
+				}
+				grown[key] = entry.Raw
+			}
+		}
+	}
+
+	keys := make([]entryKey, 0, len(grown))
+	for key := range grown {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].offset < keys[j].offset
+	})
+
+	for _, key := range keys {
+		raw := grown[key]
+		offset, err := allocator.Allocate(uint32(len(raw)))
+		if err != nil {
+			return fmt.Errorf("Cannot relocate entry at 0x%08X: %v", key.offset, err)
+		}
+
+		for _, rom := range image.Roms {
+			for _, directory := range rom.Directories {
+				changed := false
+				for i := range directory.Entries {
+					directoryEntry := &directory.Entries[i].DirectoryEntry
+					if directoryEntry.IsValueEntry() ||
+						directoryEntry.Size != key.size ||
+						directory.ResolveLocation(directoryEntry, space) != key.offset {
+						continue
+					}
+
+					directory.EncodeLocation(directoryEntry, offset, space)
+					directoryEntry.Size = uint32(len(raw))
+					directory.Entries[i].Raw = raw
+					changed = true
+				}
+				if changed {
+					directory.UpdateChecksum()
+				}
+			}
+		}
+	}
+	return nil
+}
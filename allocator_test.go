@@ -0,0 +1,111 @@
+package amdfw
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func mockAllocatorImage() (*Image, []byte) {
+	imageBytes := bytes.Repeat([]byte{0xFF}, testImage16MB)
+	copy(imageBytes, make([]byte, FETDefaultOffset))
+	copy(imageBytes[FETDefaultOffset:], fetBytes)
+
+	blob := bytes.Repeat([]byte{0x11}, 0x1000)
+	copy(imageBytes[0x102000:], blob)
+	copy(imageBytes[0x103000:], blob)
+
+	pspDirectory := Directory{
+		Header:   DirectoryHeader{Cookie: [4]byte{'$', 'P', 'S', 'P'}, TotalEntries: 4},
+		Location: 0x101000,
+		Entries: []Entry{
+			{DirectoryEntry: DirectoryEntry{Type: 0x1, Size: 0x1000, Location: 0xFF102000}, Raw: imageBytes[0x102000:0x103000]},
+			{DirectoryEntry: DirectoryEntry{Type: 0x8, Size: 0x1000, Location: 0xFF103000}, Raw: imageBytes[0x103000:0x104000]},
+			{DirectoryEntry: DirectoryEntry{Type: 0xb, Size: ValueEntrySize, Location: 0x1}},
+			{DirectoryEntry: DirectoryEntry{Type: 0x40, Size: 0x20, Location: 0xFF104000}},
+		},
+	}
+	secondDirectory := Directory{
+		Header:   DirectoryHeader{Cookie: [4]byte{'$', 'P', 'L', '2'}, TotalEntries: 1},
+		Location: 0x104000,
+		Entries: []Entry{
+			{DirectoryEntry: DirectoryEntry{Type: 0x1, Size: 0x1000, Location: 0x102000}, Raw: imageBytes[0x102000:0x103000]},
+		},
+	}
+
+	mapping := DefaultFlashMapping
+	fet := testFet
+	return &Image{
+		FET:          &fet,
+		FlashMapping: &mapping,
+		Roms: []*Rom{{
+			Type:        PSPRom,
+			Directories: []*Directory{&pspDirectory, &secondDirectory},
+		}},
+	}, imageBytes
+}
+
+func TestAllocator_Allocate(t *testing.T) {
+	image, imageBytes := mockAllocatorImage()
+	allocator := NewAllocator(image, imageBytes, DefaultAlignment)
+
+	first, err := allocator.Allocate(0x1800)
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(0x21000), first)
+
+	second, err := allocator.Allocate(0x10)
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(0x23000), second)
+}
+
+func TestAllocator_AllocateSkipsData(t *testing.T) {
+	image, imageBytes := mockAllocatorImage()
+	imageBytes[0x21800] = 0x00
+	allocator := NewAllocator(image, imageBytes, DefaultAlignment)
+
+	offset, err := allocator.Allocate(0x1000)
+
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(0x22000), offset)
+}
+
+func TestAllocator_AllocateFull(t *testing.T) {
+	image, imageBytes := mockAllocatorImage()
+	allocator := NewAllocator(image, imageBytes, DefaultAlignment)
+
+	offset, err := allocator.Allocate(testImage16MB)
+
+	assert.EqualError(t, err, "No free space for 0x1000000 bytes")
+	assert.Equal(t, uint32(0), offset)
+}
+
+func TestImage_RelocateEntries(t *testing.T) {
+	image, imageBytes := mockAllocatorImage()
+	grown := bytes.Repeat([]byte{0x22}, 0x1800)
+	image.Roms[0].Directories[0].Entries[0].Raw = grown
+
+	err := image.Roms[0].Directories[0].Write(imageBytes, DefaultFlashMapping)
+	assert.EqualError(t, err, "Entry at 0xFF102000 outgrew its space (0x1800 > 0x1000 bytes): Relocate first")
+
+	err = image.RelocateEntries(imageBytes, DefaultAlignment)
+	assert.Nil(t, err)
+
+	first := image.Roms[0].Directories[0].Entries[0].DirectoryEntry
+	assert.Equal(t, uint32(0xFF021000), first.Location)
+	assert.Equal(t, uint32(0x1800), first.Size)
+
+	alias := image.Roms[0].Directories[1].Entries[0]
+	assert.Equal(t, uint32(0x21000), alias.DirectoryEntry.Location)
+	assert.Equal(t, uint32(0x1800), alias.DirectoryEntry.Size)
+	assert.Equal(t, grown, alias.Raw)
+
+	untouched := image.Roms[0].Directories[0].Entries[1].DirectoryEntry
+	assert.Equal(t, uint32(0xFF103000), untouched.Location)
+
+	valid, _ := image.Roms[0].Directories[0].ValidateChecksum()
+	assert.True(t, valid)
+
+	_, err = image.Write(imageBytes)
+	assert.Nil(t, err)
+	assert.Equal(t, grown, imageBytes[0x21000:0x22800])
+}
@@ -8,6 +8,8 @@ import (
 	"log"
 	"os"
 	"reflect"
+	"strconv"
+	"strings"
 )
 
 func main() {
@@ -18,19 +20,123 @@ func main() {
 		log.Fatal("Could not read file: ", err)
 	}
 
-	image, err := amdfw.ParseImage(imageBytes)
+	options := amdfw.ParseOptions{}
+	if len(os.Args) > 2 {
+		baseOffset, err := strconv.ParseUint(os.Args[2], 0, 32)
+		if err != nil {
+			log.Fatal("Invalid base offset: ", err)
+		}
+		options.BaseOffset = uint32(baseOffset)
+	}
+
+	image, err := amdfw.ParseImageWithOptions(imageBytes, options)
 
 	if err != nil {
 		log.Println("Error while parse Image: ", err.Error())
 	}
 
-	renderFET(*image)
+	if image.FET != nil {
+		renderFET(*image)
+	}
+	for _, candidate := range image.AlternativeFETs {
+		log.Printf("Ignored FirmwareEntryTable at 0x%08X (score %d)", candidate.Offset, candidate.Score)
+	}
 
 	for _, rom := range image.Roms {
 		println()
 		renderRom(*rom)
 	}
 
+	println()
+	renderLayout(image.Layout(imageBytes))
+
+	println()
+	renderOverlaps(image.AnalyzeOverlaps())
+
+	println()
+	renderBootChains(image)
+}
+
+// Shows which directories each SoC listed in a combo directory boots from
+func renderBootChains(image *amdfw.Image) {
+	t := table.NewWriter()
+	t.SetOutputMirror(os.Stdout)
+	t.SetStyle(table.StyleColoredBright)
+	t.AppendHeader(table.Row{"SoC", "PSP", "BIOS"})
+
+	seen := make(map[amdfw.SoC]bool)
+	for _, rom := range image.Roms {
+		for _, directory := range rom.Directories {
+			for _, combo := range directory.ComboEntries() {
+				soc := amdfw.SoC{PSPID: combo.SoCID}
+				if combo.IDSelect == amdfw.ChipFamilyIDSelect {
+					soc = amdfw.SoC{ChipFamilyID: combo.SoCID}
+				}
+				if seen[soc] {
+					continue
+				}
+				seen[soc] = true
+
+				chain, err := image.ResolveForSoC(soc)
+				if err != nil {
+					t.AppendRow(table.Row{soc.String(), err.Error(), ""})
+					continue
+				}
+				t.AppendRow(table.Row{soc.String(), renderChain(chain.PSPDirectories), renderChain(chain.BIOSDirectories)})
+			}
+		}
+	}
+
+	if len(seen) != 0 {
+		t.Render()
+	}
+}
+
+func renderChain(directories []*amdfw.Directory) string {
+	var parts []string
+	for _, directory := range directories {
+		parts = append(parts, fmt.Sprintf("%s @ 0x%08X", string(directory.Header.Cookie[:]), directory.Location))
+	}
+	return strings.Join(parts, " -> ")
+}
+
+func renderOverlaps(report amdfw.OverlapReport) {
+	t := table.NewWriter()
+	t.SetOutputMirror(os.Stdout)
+	t.SetStyle(table.StyleColoredBright)
+	t.AppendHeader(table.Row{"Entry", "Overlaps", "Kind"})
+	for _, overlap := range report.Entries {
+		t.AppendRow(table.Row{overlap.A, overlap.B, overlap.Kind})
+	}
+	for _, overlap := range report.Tables {
+		t.AppendRow(table.Row{overlap.Entry, fmt.Sprintf("%s @ 0x%08X", overlap.Table.Owners[0], overlap.Table.Start), overlap.Table.Type})
+	}
+	t.Render()
+}
+
+func renderLayout(layout []amdfw.Region) {
+	t := table.NewWriter()
+	t.SetOutputMirror(os.Stdout)
+	t.SetStyle(table.StyleColoredBright)
+
+	t.AppendHeader(table.Row{"Address Map"})
+	t.Render()
+
+	t = table.NewWriter()
+	t.SetOutputMirror(os.Stdout)
+	t.SetStyle(table.StyleColoredBright)
+	t.AppendHeader(table.Row{"Start", "End", "Size", "Type", "Owner"})
+	for _, region := range layout {
+		t.AppendRow(table.Row{
+			fmt.Sprintf("0x%08X", region.Start),
+			fmt.Sprintf("0x%08X", region.End()-1),
+			fmt.Sprintf("0x%08X", region.Size),
+			region.Type,
+			strings.Join(region.Owners, "\n"),
+		})
+	}
+	t.AppendFooter(table.Row{"", "", fmt.Sprintf("0x%08X", amdfw.FreeSpace(layout)), "Free", ""})
+	t.Render()
 }
 
 func renderRom(rom amdfw.Rom) {
@@ -57,6 +163,7 @@ func renderRom(rom amdfw.Rom) {
 			{"Checksum", fmt.Sprintf("0x%08X %s", directory.Header.Checksum, checksum)},
 			{"Number of Entries", fmt.Sprintf("0x%08X", directory.Header.TotalEntries)},
 			{"Reserved", fmt.Sprintf("0x%08X", directory.Header.Reserved)},
+			{"Address Mode", directory.Header.AddressMode().String()},
 		})
 		t.Render()
 
@@ -66,6 +173,8 @@ func renderRom(rom amdfw.Rom) {
 		t.AppendHeader(table.Row{
 			"Index",
 			"Type",
+			"SubProgram",
+			"RomID",
 			"Location",
 			"Size",
 			"Name",
@@ -84,11 +193,23 @@ func renderRom(rom amdfw.Rom) {
 				name = entry.TypeInfo.Name
 			}
 
+			location := fmt.Sprintf("0x%08X", entry.DirectoryEntry.Location)
+			if mode := directory.EntryAddressMode(&entry.DirectoryEntry); mode != amdfw.PhysicalAddressMode {
+				location = fmt.Sprintf("0x%08X (%s)", entry.DirectoryEntry.Location, mode)
+			}
+			size := fmt.Sprintf("0x%08X", entry.DirectoryEntry.Size)
+			if entry.DirectoryEntry.IsValueEntry() {
+				location = fmt.Sprintf("0x%X", entry.DirectoryEntry.Value())
+				size = "Value"
+			}
+
 			nextRow := table.Row{
 				fmt.Sprintf("0x%04X", entryID),
-				fmt.Sprintf("0x%04X", entry.DirectoryEntry.Type),
-				fmt.Sprintf("0x%08X", entry.DirectoryEntry.Location),
-				fmt.Sprintf("0x%08X", entry.DirectoryEntry.Size),
+				fmt.Sprintf("0x%02X", entry.DirectoryEntry.BaseType()),
+				fmt.Sprintf("0x%X", entry.DirectoryEntry.SubProgram()),
+				fmt.Sprintf("0x%X", entry.DirectoryEntry.RomID()),
+				location,
+				size,
 				name,
 			}
 			if entry.Header != nil {
@@ -108,6 +229,9 @@ func renderRom(rom amdfw.Rom) {
 		}
 		t.Render()
 
+		renderBIOSAttributes(directory)
+		renderComboEntries(directory)
+
 		for entryID, entry := range directory.Entries {
 
 			if entry.Header != nil {
@@ -125,9 +249,152 @@ func renderRom(rom amdfw.Rom) {
 				}
 				t.Render()
 			}
+
+			if entry.PublicKey != nil {
+				renderPublicKey(entryID, entry)
+			}
 		}
 
 	}
+
+	renderSlots(rom)
+}
+
+func renderSlots(rom amdfw.Rom) {
+	if len(rom.Slots) == 0 {
+		return
+	}
+
+	t := table.NewWriter()
+	t.SetOutputMirror(os.Stdout)
+	t.SetStyle(table.StyleColoredBright)
+	t.AppendHeader(table.Row{"Slot", "Header", "Priority", "Update Retries", "Glitch Retries", "PSP ID", "Max Size", "Directory", "Active"})
+
+	active := rom.ActiveSlot()
+	for _, slot := range rom.Slots {
+		directory := "✕"
+		if slot.Directory != nil {
+			directory = fmt.Sprintf("0x%08X", slot.Directory.Location)
+		}
+
+		row := table.Row{slot.Name, "None"}
+		if slot.Header != nil {
+			checksum := "✓"
+			if valid, should := slot.Header.ValidateChecksum(); !valid {
+				checksum = fmt.Sprintf("✕ (0x%08X)", should)
+			}
+			row = table.Row{
+				slot.Name,
+				fmt.Sprintf("0x%08X %s", slot.HeaderLocation, checksum),
+				slot.Header.BootPriority,
+				slot.Header.UpdateRetryCount,
+				slot.Header.GlitchRetryCount,
+				fmt.Sprintf("0x%08X", slot.Header.PSPID),
+				fmt.Sprintf("0x%X", slot.Header.SlotMaxSize),
+			}
+		} else {
+			row = append(row, "", "", "", "", "")
+		}
+		t.AppendRow(append(row, directory, slot == active))
+	}
+	t.Render()
+}
+
+func renderBIOSAttributes(directory *amdfw.Directory) {
+	t := table.NewWriter()
+	t.SetOutputMirror(os.Stdout)
+	t.SetStyle(table.StyleColoredBright)
+	t.AppendHeader(table.Row{
+		"Index",
+		"Type",
+		"Region",
+		"Reset",
+		"Copy",
+		"ReadOnly",
+		"Zipped",
+		"Instance",
+		"SubProgram",
+		"RomID",
+		"Writable",
+		"Destination",
+	})
+
+	rows := 0
+	for entryID, entry := range directory.Entries {
+		attributes := entry.DirectoryEntry.BIOSAttributes
+		if attributes == nil {
+			continue
+		}
+
+		destination := ""
+		if entry.DirectoryEntry.Destination != nil {
+			destination = fmt.Sprintf("0x%016X", *entry.DirectoryEntry.Destination)
+		}
+
+		t.AppendRow(table.Row{
+			fmt.Sprintf("0x%04X", entryID),
+			fmt.Sprintf("0x%02X", entry.DirectoryEntry.BaseType()),
+			fmt.Sprintf("0x%02X", attributes.RegionType),
+			attributes.ResetImage,
+			attributes.CopyImage,
+			attributes.ReadOnly,
+			attributes.Compressed,
+			attributes.Instance,
+			attributes.SubProgram,
+			attributes.RomID,
+			attributes.Writable,
+			destination,
+		})
+		rows++
+	}
+
+	if rows != 0 {
+		t.Render()
+	}
+}
+
+func renderComboEntries(directory *amdfw.Directory) {
+	header := directory.ComboHeader()
+	if header == nil {
+		return
+	}
+
+	t := table.NewWriter()
+	t.SetOutputMirror(os.Stdout)
+	t.SetStyle(table.StyleColoredBright)
+	t.AppendHeader(table.Row{"Index", "ID Select", "SoC ID", "Directory"})
+
+	for entryID, combo := range directory.ComboEntries() {
+		t.AppendRow(table.Row{
+			fmt.Sprintf("0x%04X", entryID),
+			combo.IDSelect,
+			fmt.Sprintf("0x%08X", combo.SoCID),
+			fmt.Sprintf("0x%016X", combo.DirectoryPointer),
+		})
+	}
+	t.AppendFooter(table.Row{"Lookup", header.LookupMode, "", ""})
+	t.Render()
+}
+
+func renderPublicKey(entryID int, entry amdfw.Entry) {
+	key := entry.PublicKey
+
+	t := table.NewWriter()
+	t.SetOutputMirror(os.Stdout)
+
+	t.AppendHeader(table.Row{entryID, fmt.Sprintf("Public Key @ 0x%X", entry.DirectoryEntry.Location)})
+	t.AppendRows([]table.Row{
+		{"Version", fmt.Sprintf("0x%X", key.Version)},
+		{"KeyID", fmt.Sprintf("0x%X", key.KeyID)},
+		{"CertifyingID", fmt.Sprintf("0x%X", key.CertifyingID)},
+		{"KeyUsage", fmt.Sprintf("0x%X", key.KeyUsage)},
+		{"ExponentSize", fmt.Sprintf("%d", key.ExponentSize)},
+		{"ModulusSize", fmt.Sprintf("%d", key.ModulusSize)},
+		{"Exponent", fmt.Sprintf("0x%X", key.Exponent)},
+		{"Modulus", fmt.Sprintf("0x%X", key.Modulus)},
+		{"Signed", fmt.Sprintf("%t", len(key.Signature) != 0)},
+	})
+	t.Render()
 }
 
 func renderFET(image amdfw.Image) {
@@ -139,20 +406,57 @@ func renderFET(image amdfw.Image) {
 	t.AppendHeader(table.Row{"Firmware Entry Table"})
 	t.Render()
 
+	fet := image.FET
+
 	t = table.NewWriter()
 	t.SetOutputMirror(os.Stdout)
 	t.SetStyle(table.StyleColoredBright)
 	t.AppendHeader(table.Row{"Field", "Value"})
 	t.AppendRows([]table.Row{
-		{"Signature", fmt.Sprintf("0x%08X", image.FET.Signature)},
-		{"ImcRomBase", fmt.Sprintf("0x%08X", *image.FET.ImcRomBase)},
-		{"GecRomBase", fmt.Sprintf("0x%08X", *image.FET.GecRomBase)},
-		{"XHCRomBase", fmt.Sprintf("0x%08X", *image.FET.XHCRomBase)},
-		{"PSPDirBase", fmt.Sprintf("0x%08X", *image.FET.PSPDirBase)},
-		{"NewPSPDirBase", fmt.Sprintf("0x%08X", *image.FET.NewPSPDirBase)},
-		{"BHDDirBase", fmt.Sprintf("0x%08X", *image.FET.BHDDirBase)},
-		{"NewBHDDirBase", fmt.Sprintf("0x%08X", *image.FET.NewBHDDirBase)},
+		{"Signature", fmt.Sprintf("0x%08X", fet.Signature)},
+		{"ImcRomBase", renderWord(fet.ImcRomBase)},
+		{"GecRomBase", renderWord(fet.GecRomBase)},
+		{"XHCRomBase", renderWord(fet.XHCRomBase)},
+		{"PSPDirBase", renderWord(fet.PSPDirBase)},
+		{"NewPSPDirBase", renderWord(fet.NewPSPDirBase)},
+		{"BHDDirBase", renderWord(fet.BHDDirBase)},
+		{"NewBHDDirBase", renderWord(fet.NewBHDDirBase)},
 	})
+
+	if fet.EFSGeneration != nil {
+		t.AppendRows([]table.Row{
+			{"BIOS2DirBase", renderWord(fet.BIOS2DirBase)},
+			{"EFSGeneration", fmt.Sprintf("%s (second gen: %t)", renderWord(fet.EFSGeneration), fet.IsSecondGen())},
+			{"BIOS3DirBase", renderWord(fet.BIOS3DirBase)},
+			{"PromontoryFWBase", renderWord(fet.PromontoryFWBase)},
+			{"LPPromontoryFWBase", renderWord(fet.LPPromontoryFWBase)},
+			{"SPIReadModeF15", renderSetting(fet.SPIReadModeF15, amdfw.SPIReadModeName)},
+			{"SPIFastSpeedF15", renderSetting(fet.SPIFastSpeedF15, amdfw.SPIFastSpeedName)},
+			{"SPIReadModeF17", renderSetting(fet.SPIReadModeF17, amdfw.SPIReadModeName)},
+			{"SPIFastSpeedF17", renderSetting(fet.SPIFastSpeedF17, amdfw.SPIFastSpeedName)},
+			{"QPRDummyCycleF17", renderSetting(fet.QPRDummyCycleF17, nil)},
+			{"SPIReadModeF17Mod30", renderSetting(fet.SPIReadModeF17Mod30, amdfw.SPIReadModeName)},
+			{"SPIFastSpeedF17Mod30", renderSetting(fet.SPIFastSpeedF17Mod30, amdfw.SPIFastSpeedName)},
+			{"MicronModeF17Mod30", renderSetting(fet.MicronModeF17Mod30, amdfw.MicronModeName)},
+		})
+	}
 	t.Render()
 
 }
+
+func renderWord(value *uint32) string {
+	if value == nil {
+		return "-"
+	}
+	return fmt.Sprintf("0x%08X", *value)
+}
+
+func renderSetting(value *uint8, name func(uint8) string) string {
+	if value == nil {
+		return "-"
+	}
+	if name == nil {
+		return fmt.Sprintf("0x%02X", *value)
+	}
+	return fmt.Sprintf("0x%02X %s", *value, name(*value))
+}
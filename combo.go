@@ -0,0 +1,109 @@
+package amdfw
+
+import (
+	"fmt"
+)
+
+const (
+	// The PSP tries every entry until one of the directories can be used
+	DynamicComboLookup ComboLookupMode = 0
+	// The PSP picks the entry matching its ID
+	IDComboLookup ComboLookupMode = 1
+)
+
+const (
+	PSPIDSelect        ComboIDSelect = 0
+	ChipFamilyIDSelect ComboIDSelect = 1
+)
+
+type (
+	ComboLookupMode uint32
+
+	// What the SoC ID of a combo entry is compared to
+	ComboIDSelect uint32
+
+	// Combo directories extend the DirectoryHeader by 16 reserved bytes.
+	// The lookup mode is stored in DirectoryHeader.Reserved.
+	ComboHeader struct {
+		LookupMode ComboLookupMode
+		Reserved   [4]uint32
+	}
+
+	// Combo directories reuse the DirectoryEntry layout: Type selects the ID,
+	// Size holds the SoC ID and Location and Reserved form the directory pointer
+	ComboEntry struct {
+		IDSelect         ComboIDSelect
+		SoCID            uint32
+		DirectoryPointer uint64
+	}
+)
+
+func (mode ComboLookupMode) String() string {
+	switch mode {
+	case DynamicComboLookup:
+		return "dynamic"
+	case IDComboLookup:
+		return "ID match"
+	default:
+		return fmt.Sprintf("unknown (%d)", uint32(mode))
+	}
+}
+
+func (idSelect ComboIDSelect) String() string {
+	switch idSelect {
+	case PSPIDSelect:
+		return "PSP ID"
+	case ChipFamilyIDSelect:
+		return "chip family ID"
+	default:
+		return fmt.Sprintf("unknown (%d)", uint32(idSelect))
+	}
+}
+
+// Combo directories point to one directory per processor instead of firmware entries
+func (directory *Directory) IsCombo() bool {
+	cookie := string(directory.Header.Cookie[:])
+	return cookie == DUALPSPCOOCKIE || cookie == DUALBHDCOOCKIE
+}
+
+// Returns nil if the directory is not a combo directory
+func (directory *Directory) ComboHeader() *ComboHeader {
+	if !directory.IsCombo() {
+		return nil
+	}
+	return &ComboHeader{
+		LookupMode: ComboLookupMode(directory.Header.Reserved),
+		Reserved:   directory.ComboReserved,
+	}
+}
+
+// Returns nil if the directory is not a combo directory
+func (directory *Directory) ComboEntries() []ComboEntry {
+	if !directory.IsCombo() {
+		return nil
+	}
+	entries := make([]ComboEntry, len(directory.Entries))
+	for i := range directory.Entries {
+		entries[i] = directory.Entries[i].DirectoryEntry.ComboEntry()
+	}
+	return entries
+}
+
+// Interprets the entry as entry of a combo directory
+func (entry *DirectoryEntry) ComboEntry() ComboEntry {
+	return ComboEntry{
+		IDSelect:         ComboIDSelect(entry.Type),
+		SoCID:            entry.Size,
+		DirectoryPointer: uint64(entry.Reserved)<<32 | uint64(entry.Location),
+	}
+}
+
+// Builds the DirectoryEntry of a combo directory
+func (combo ComboEntry) DirectoryEntry() DirectoryEntry {
+	return DirectoryEntry{
+		Type:     uint32(combo.IDSelect),
+		Size:     combo.SoCID,
+		Location: uint32(combo.DirectoryPointer),
+		Reserved: uint32(combo.DirectoryPointer >> 32),
+	}
+}
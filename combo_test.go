@@ -0,0 +1,105 @@
+package amdfw
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestDirectory_ComboEntries(t *testing.T) {
+	imageBytes := make([]byte, testImage16MB)
+	copy(imageBytes[testPSPDirBase-DefaultFlashMapping:], test2PSPDirectoryBytes)
+
+	directory, err := ParseDirectory(imageBytes, testPSPDirBase, DefaultFlashMapping)
+
+	assert.Nil(t, err)
+	assert.True(t, directory.IsCombo())
+	assert.Equal(t, &ComboHeader{LookupMode: DynamicComboLookup}, directory.ComboHeader())
+	assert.Equal(t, []ComboEntry{
+		{IDSelect: PSPIDSelect, SoCID: 0xbc0b0500, DirectoryPointer: 0x002e9000},
+		{IDSelect: PSPIDSelect, SoCID: 0xbc0a0000, DirectoryPointer: 0xff1dd000},
+		{IDSelect: PSPIDSelect, SoCID: 0xbc0a0100, DirectoryPointer: 0xff1dd000},
+		{IDSelect: PSPIDSelect, SoCID: 0xbc090000, DirectoryPointer: 0xff111000},
+	}, directory.ComboEntries())
+	assert.Equal(t, "Full PSP Directory for PSP ID 0xBC0B0500", directory.Entries[0].TypeInfo.Comment)
+	assert.Nil(t, directory.Entries[0].Raw)
+}
+
+func TestDirectory_ComboEntriesNotCombo(t *testing.T) {
+	assert.False(t, testPSPDirectory.IsCombo())
+	assert.Nil(t, testPSPDirectory.ComboHeader())
+	assert.Nil(t, testPSPDirectory.ComboEntries())
+}
+
+func TestDirectory_ComboHeaderRoundTrip(t *testing.T) {
+	directory := Directory{
+		Header: DirectoryHeader{
+			Cookie:       [4]byte{'2', 'P', 'S', 'P'},
+			TotalEntries: 1,
+			Reserved:     uint32(IDComboLookup),
+		},
+		Location:      0x1000,
+		ComboReserved: [4]uint32{0x1, 0x2, 0x3, 0x4},
+		Entries: []Entry{
+			{DirectoryEntry: ComboEntry{IDSelect: ChipFamilyIDSelect, SoCID: 0x8A, DirectoryPointer: 0x2000}.DirectoryEntry()},
+		},
+	}
+	directory.UpdateChecksum()
+
+	imageBytes := make([]byte, 0x10000)
+	assert.Nil(t, directory.Write(imageBytes, 0))
+
+	parsed, err := ParseDirectory(imageBytes, 0x1000, 0)
+
+	assert.Nil(t, err)
+	assert.Equal(t, &ComboHeader{LookupMode: IDComboLookup, Reserved: [4]uint32{0x1, 0x2, 0x3, 0x4}}, parsed.ComboHeader())
+	assert.Equal(t, []ComboEntry{{IDSelect: ChipFamilyIDSelect, SoCID: 0x8A, DirectoryPointer: 0x2000}}, parsed.ComboEntries())
+	valid, _ := parsed.ValidateChecksum()
+	assert.True(t, valid)
+}
+
+func TestParseBHDRomCombo(t *testing.T) {
+	imageBytes := make([]byte, 0x100000)
+
+	bhd := Directory{Location: 0x30000}
+	copy(bhd.Header.Cookie[:], BHDCOOCKIE)
+	destination := uint64(0x1000)
+	assert.Nil(t, bhd.AddEntry(Entry{DirectoryEntry: DirectoryEntry{Type: 0x60, Size: 0x10, Location: 0x31000, Destination: &destination}}))
+	assert.Nil(t, bhd.Write(imageBytes, 0))
+
+	combo := Directory{Location: 0x20000}
+	copy(combo.Header.Cookie[:], DUALBHDCOOCKIE)
+	combo.Entries = []Entry{{DirectoryEntry: ComboEntry{SoCID: 0xBC0A0000, DirectoryPointer: 0x30000}.DirectoryEntry()}}
+	combo.Header.TotalEntries = 1
+	combo.UpdateChecksum()
+	assert.Nil(t, combo.Write(imageBytes, 0))
+
+	base := uint32(0x20000)
+	rom, err := ParseBHDRom(imageBytes, &FirmwareEntryTable{BHDDirBase: &base}, 0)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(rom.Directories))
+	assert.True(t, rom.Directories[0].IsCombo())
+	assert.Equal(t, "BIOS_DIRECTORY", rom.Directories[0].Entries[0].TypeInfo.Name)
+	assert.Equal(t, []ComboEntry{{SoCID: 0xBC0A0000, DirectoryPointer: 0x30000}}, rom.Directories[0].ComboEntries())
+	valid, _ := rom.Directories[0].ValidateChecksum()
+	assert.True(t, valid)
+
+	assert.Equal(t, BHDCOOCKIE, string(rom.Directories[1].Header.Cookie[:]))
+	assert.Equal(t, uint32(0x30000), rom.Directories[1].Location)
+	assert.Equal(t, destination, *rom.Directories[1].Entries[0].DirectoryEntry.Destination)
+}
+
+func TestDirectory_ValidateChecksum2BHD(t *testing.T) {
+	combo := Directory{Location: 0x20000}
+	copy(combo.Header.Cookie[:], DUALBHDCOOCKIE)
+	combo.Entries = []Entry{{DirectoryEntry: ComboEntry{SoCID: 0xBC0A0000, DirectoryPointer: 0x30000}.DirectoryEntry()}}
+	combo.Header.TotalEntries = 1
+	combo.UpdateChecksum()
+
+	valid, _ := combo.ValidateChecksum()
+	assert.True(t, valid)
+
+	combo.ComboReserved[0] = 1
+	valid, _ = combo.ValidateChecksum()
+	assert.False(t, valid)
+}
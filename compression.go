@@ -0,0 +1,148 @@
+package amdfw
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+const EntryHeaderSize = 0x100
+
+// Limit for entries without FullSize, larger than any supported flash
+const maxDecompressedSize = 0x4000000
+
+// Returns the body of the entry without its header. Compressed bodies are inflated.
+func (entry Entry) Payload() ([]byte, error) {
+	if entry.Header == nil {
+		return nil, fmt.Errorf("Cannot get Payload: Entry has no header")
+	}
+
+	if entry.Header.IsCompressed != 0 {
+		return entry.Decompress()
+	}
+
+	body, err := entry.body()
+	if err != nil {
+		return nil, fmt.Errorf("Cannot get Payload: %v", err)
+	}
+
+	size := entry.Header.SizeSigned
+	if size == 0 {
+		size = entry.Header.FullSize
+	}
+	if size != 0 && int(size) <= len(body) {
+		body = body[:size]
+	}
+
+	return body, nil
+}
+
+// Inflates the zlib stream following the header and validates its length against FullSize.
+// Reading stops after FullSize bytes, so hostile streams cannot exhaust memory.
+func (entry Entry) Decompress() ([]byte, error) {
+	if entry.Header == nil {
+		return nil, fmt.Errorf("Cannot decompress: Entry has no header")
+	}
+
+	if entry.Header.IsCompressed == 0 {
+		return nil, fmt.Errorf("Cannot decompress: Entry is not compressed")
+	}
+
+	body, err := entry.body()
+	if err != nil {
+		return nil, fmt.Errorf("Cannot decompress: %v", err)
+	}
+
+	reader, err := zlib.NewReader(bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("Cannot decompress: %v", err)
+	}
+	defer reader.Close()
+
+	limit := int64(entry.Header.FullSize)
+	if limit == 0 {
+		limit = maxDecompressedSize
+	}
+
+	payload, err := ioutil.ReadAll(io.LimitReader(reader, limit+1))
+	if err != nil {
+		return nil, fmt.Errorf("Cannot decompress: %v", err)
+	}
+
+	if int64(len(payload)) > limit {
+		return nil, fmt.Errorf("Cannot decompress: Output exceeds 0x%X bytes", limit)
+	}
+
+	if entry.Header.FullSize != 0 && uint32(len(payload)) != entry.Header.FullSize {
+		return nil, fmt.Errorf("Cannot decompress: Expected 0x%X bytes but got 0x%X", entry.Header.FullSize, len(payload))
+	}
+
+	return payload, nil
+}
+
+// Returns the bytes between header and signature
+func (entry Entry) body() ([]byte, error) {
+	end := len(entry.Raw) - len(entry.Signature)
+
+	if end < EntryHeaderSize {
+		return nil, fmt.Errorf("Entry to small (0x%X bytes)", len(entry.Raw))
+	}
+
+	return entry.Raw[EntryHeaderSize:end], nil
+}
+
+// Builds a new compressed Entry from a plain payload.
+// The header is taken from template with all size and compression fields updated.
+// The signature is appended as is, pass nil for unsigned entries.
+func NewCompressedEntry(directoryEntry DirectoryEntry, template EntryHeader, payload []byte, signature []byte) (*Entry, error) {
+	compressed := new(bytes.Buffer)
+
+	writer, err := zlib.NewWriterLevel(compressed, zlib.BestCompression)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot compress payload: %v", err)
+	}
+	if _, err = writer.Write(payload); err != nil {
+		return nil, fmt.Errorf("Cannot compress payload: %v", err)
+	}
+	if err = writer.Close(); err != nil {
+		return nil, fmt.Errorf("Cannot compress payload: %v", err)
+	}
+
+	zlibSize := compressed.Len()
+
+	// Signatures start 16 byte aligned
+	if padding := zlibSize % 0x10; padding != 0 {
+		compressed.Write(make([]byte, 0x10-padding))
+	}
+
+	header := template
+	header.IsCompressed = 1
+	header.FullSize = uint32(len(payload))
+	header.SizeSigned = uint32(len(payload))
+	header.ZlibSize = uint32(zlibSize)
+	header.SizePacked = uint32(EntryHeaderSize + compressed.Len() + len(signature))
+
+	raw := new(bytes.Buffer)
+	if err = binary.Write(raw, binary.LittleEndian, header); err != nil {
+		return nil, fmt.Errorf("Writing binary failed: %v", err)
+	}
+	raw.Write(compressed.Bytes())
+	raw.Write(signature)
+
+	entry := Entry{
+		DirectoryEntry: directoryEntry,
+		Header:         &header,
+		Raw:            raw.Bytes(),
+		TypeInfo:       lookupEntryTypeInfo(directoryEntry),
+	}
+	entry.DirectoryEntry.Size = header.SizePacked
+	if len(signature) != 0 {
+		entry.Signature = entry.Raw[len(entry.Raw)-len(signature):]
+	}
+	entry.Version = fmt.Sprintf("%X.%X.%X.%X", header.Version[3], header.Version[2], header.Version[1], header.Version[0])
+
+	return &entry, nil
+}
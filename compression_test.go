@@ -0,0 +1,127 @@
+package amdfw
+
+import (
+	"bytes"
+	"compress/zlib"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestEntry_Decompress(t *testing.T) {
+	payload, err := testEntry.Decompress()
+
+	assert.Nil(t, err)
+	assert.Equal(t, int(testEntryHeader.FullSize), len(payload))
+	assert.Equal(t, []byte{0x18, 0xd0, 0x9f, 0xe5}, payload[:4])
+}
+
+func TestEntry_DecompressNotCompressed(t *testing.T) {
+	header := testEntryHeader
+	header.IsCompressed = 0
+	entry := testEntry
+	entry.Header = &header
+
+	payload, err := entry.Decompress()
+
+	assert.EqualError(t, err, "Cannot decompress: Entry is not compressed")
+	assert.Nil(t, payload)
+}
+
+func TestEntry_DecompressWrongSize(t *testing.T) {
+	header := testEntryHeader
+	header.FullSize = 0x100
+	entry := testEntry
+	entry.Header = &header
+
+	payload, err := entry.Decompress()
+
+	assert.EqualError(t, err, "Cannot decompress: Output exceeds 0x100 bytes")
+	assert.Nil(t, payload)
+
+	header.FullSize = 0x1000
+	payload, err = entry.Decompress()
+
+	assert.EqualError(t, err, "Cannot decompress: Expected 0x1000 bytes but got 0x4A8")
+	assert.Nil(t, payload)
+}
+
+func TestEntry_DecompressBomb(t *testing.T) {
+	// 16MB of zeros compress to a few KB
+	compressed := new(bytes.Buffer)
+	writer := zlib.NewWriter(compressed)
+	writer.Write(make([]byte, 0x1000000))
+	writer.Close()
+
+	header := testEntryHeader
+	header.FullSize = 0x1000
+	entry := Entry{
+		Header: &header,
+		Raw:    append(make([]byte, EntryHeaderSize), compressed.Bytes()...),
+	}
+
+	payload, err := entry.Decompress()
+
+	assert.EqualError(t, err, "Cannot decompress: Output exceeds 0x1000 bytes")
+	assert.Nil(t, payload)
+}
+
+func TestEntry_Payload(t *testing.T) {
+	compressed, err := testEntry.Payload()
+	assert.Nil(t, err)
+	assert.Equal(t, int(testEntryHeader.FullSize), len(compressed))
+
+	header := testEntryHeader
+	header.IsCompressed = 0
+	header.SizeSigned = 0x10
+	entry := Entry{
+		Header: &header,
+		Raw:    append(make([]byte, EntryHeaderSize), bytes.Repeat([]byte{0xAB}, 0x20)...),
+	}
+
+	plain, err := entry.Payload()
+	assert.Nil(t, err)
+	assert.Equal(t, bytes.Repeat([]byte{0xAB}, 0x10), plain)
+}
+
+func TestNewCompressedEntry(t *testing.T) {
+	payload := bytes.Repeat([]byte("AMD PSP"), 0x200)
+
+	entry, err := NewCompressedEntry(testDirectoryEntry, testEntryHeader, payload, expectedSignature)
+
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(1), entry.Header.IsCompressed)
+	assert.Equal(t, uint32(len(payload)), entry.Header.FullSize)
+	assert.Equal(t, uint32(len(payload)), entry.Header.SizeSigned)
+	assert.Equal(t, uint32(len(entry.Raw)), entry.Header.SizePacked)
+	assert.Equal(t, entry.Header.SizePacked, entry.DirectoryEntry.Size)
+	assert.Equal(t, 0, len(entry.Raw)%0x10)
+	assert.Equal(t, expectedSignature, entry.Signature)
+
+	// Round trip through the image
+	imageBytes := make([]byte, testImage16MB)
+	err = entry.Write(imageBytes, entry.DirectoryEntry.Location-DefaultFlashMapping)
+	assert.Nil(t, err)
+
+	parsed, err := ParseEntry(imageBytes, entry.DirectoryEntry, DefaultFlashMapping)
+	assert.Nil(t, err)
+	assert.Equal(t, *entry.Header, *parsed.Header)
+
+	decompressed, err := parsed.Decompress()
+	assert.Nil(t, err)
+	assert.Equal(t, payload, decompressed)
+}
+
+func TestNewCompressedEntryTypeInfo(t *testing.T) {
+	payload := bytes.Repeat([]byte("AMD PSP"), 0x10)
+	directoryEntry := testDirectoryEntry
+
+	directoryEntry.Type = 0x108
+	entry, err := NewCompressedEntry(directoryEntry, testEntryHeader, payload, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "PSP_SMU_FN_FIRMWARE", entry.TypeInfo.Name)
+
+	directoryEntry.Type = 0x20208
+	entry, err = NewCompressedEntry(directoryEntry, testEntryHeader, payload, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "SMU_OFFCHIP_FW", entry.TypeInfo.Name)
+}
@@ -4,20 +4,28 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
-	"io"
 )
 
 const PSPCOOCKIE = "$PSP"
 const DUALPSPCOOCKIE = "2PSP"
+const DUALBHDCOOCKIE = "2BHD"
 const BHDCOOCKIE = "$BHD"
 const SECONDPSPCOOCKIE = "$PL2"
 const SECONDBHDCOOCKIE = "$BL2"
 
+// Entries of this size carry an immediate value instead of pointing to data
+const ValueEntrySize = uint32(0xFFFFFFFF)
+
 type (
 	Directory struct {
 		Header   DirectoryHeader
 		Entries  []Entry
 		Location uint32
+		// Flash offset slot relative locations are based on: the Image Slot Header
+		// of the slot holding the directory, or the directory itself outside of slots
+		SlotBase uint32
+		// Combo directories only: reserved words following the header
+		ComboReserved [4]uint32
 	}
 
 	DirectoryHeader struct {
@@ -32,7 +40,23 @@ type (
 		Size     uint32
 		Location uint32
 		Reserved uint32
-		Unknown  *uint64
+		// BIOS directories only
+		BIOSAttributes *BIOSEntryAttributes
+		Destination    *uint64
+	}
+
+	// Upper 24 bits of the type word in BIOS directories
+	BIOSEntryAttributes struct {
+		RegionType uint8 // 8-15
+		ResetImage bool  // 16
+		CopyImage  bool  // 17
+		ReadOnly   bool  // 18
+		Compressed bool  // 19
+		Instance   uint8 // 20-23
+		SubProgram uint8 // 24-26
+		RomID      uint8 // 27-28
+		Writable   bool  // 29
+		Reserved   uint8 // 30-31
 	}
 
 	binaryDirectoryEntry struct {
@@ -44,17 +68,26 @@ type (
 )
 
 func ParseDirectory(firmwareBytes []byte, address uint32, flashMapping uint32) (*Directory, error) {
-	directory := Directory{}
+	return ParseDirectoryInSpace(firmwareBytes, address, NewAddressSpace(flashMapping, 0, firmwareBytes))
+}
 
-	if address > flashMapping {
-		address -= flashMapping
-	}
+// Parses a directory of an image described by space. The Location of the directory is a flash offset.
+func ParseDirectoryInSpace(firmwareBytes []byte, address uint32, space AddressSpace) (*Directory, error) {
+	return parseDirectoryInSlot(firmwareBytes, address, space.ToFlashOffset(address), space)
+}
+
+// Parses a directory whose slot relative entries are based on the flash offset slotBase
+func parseDirectoryInSlot(firmwareBytes []byte, address uint32, slotBase uint32, space AddressSpace) (*Directory, error) {
+	directory := Directory{}
 
-	if address > uint32(len(firmwareBytes)) {
+	relative, inImage := space.ToRelative(address)
+	if !inImage {
 		return nil, fmt.Errorf("Firmwarebytes not long enough for reading directory header..")
 	}
 
-	directory.Location = address
+	directory.Location = space.ToFlashOffset(address)
+	directory.SlotBase = slotBase
+	address = relative
 	directoryBytes := firmwareBytes[address:]
 	directoryReader := bytes.NewReader(directoryBytes)
 
@@ -68,7 +101,7 @@ func ParseDirectory(firmwareBytes []byte, address uint32, flashMapping uint32) (
 
 	isCookieKnown := false
 
-	for _, c := range []string{PSPCOOCKIE, DUALPSPCOOCKIE, SECONDPSPCOOCKIE, BHDCOOCKIE, SECONDBHDCOOCKIE} {
+	for _, c := range directoryCookies {
 		if isCookieKnown = c == cookie; isCookieKnown {
 			break
 		}
@@ -84,9 +117,9 @@ func ParseDirectory(firmwareBytes []byte, address uint32, flashMapping uint32) (
 		return nil, fmt.Errorf("Could not read directory entries from image: Too many entries(%d)!", directory.Header.TotalEntries)
 	}
 
-	if cookie == DUALPSPCOOCKIE {
-		if _, err := directoryReader.Seek(16, io.SeekCurrent); err != nil {
-			return nil, fmt.Errorf("Could not read 2PSP directory header: %v", err)
+	if directory.IsCombo() {
+		if err := binary.Read(directoryReader, binary.LittleEndian, &directory.ComboReserved); err != nil {
+			return nil, fmt.Errorf("Could not read %s directory header: %v", cookie, err)
 		}
 	}
 
@@ -105,20 +138,39 @@ func ParseDirectory(firmwareBytes []byte, address uint32, flashMapping uint32) (
 		directoryEntry.Location = binDirEntry.Location
 		directoryEntry.Reserved = binDirEntry.Reserved
 
-		entry, _ := ParseEntry(firmwareBytes, directoryEntry, flashMapping)
+		if directory.IsCombo() {
+			// Type and Size hold the ID the directory is selected by, there is no data to parse
+			combo := directoryEntry.ComboEntry()
+			typeInfo := TypeInfo{
+				Name:    "PSP_DIRECTORY",
+				Comment: fmt.Sprintf("Full PSP Directory for %s 0x%08X", combo.IDSelect, combo.SoCID),
+			}
+			if cookie == DUALBHDCOOCKIE {
+				typeInfo = TypeInfo{
+					Name:    "BIOS_DIRECTORY",
+					Comment: fmt.Sprintf("Full BIOS Directory for %s 0x%08X", combo.IDSelect, combo.SoCID),
+				}
+			}
+			directory.Entries[i] = Entry{
+				DirectoryEntry: directoryEntry,
+				TypeInfo:       &typeInfo,
+			}
+			continue
+		}
 
-		if cookie == DUALPSPCOOCKIE {
-			entry.TypeInfo.Name = "PSP_DIRECTORY"
-			entry.TypeInfo.Comment = "Full PSP Directory"
-		} else if cookie == BHDCOOCKIE || cookie == SECONDBHDCOOCKIE {
-			//BHD Entries adds 2 additional bytes
-			unknownBytes := make([]byte, 8)
-			if c, err := directoryReader.Read(unknownBytes); err != nil || c != 8 {
+		entry, _ := parseEntryAt(firmwareBytes, directoryEntry, directory.ResolveLocation(&directoryEntry, space), space)
+
+		if cookie == BHDCOOCKIE || cookie == SECONDBHDCOOCKIE {
+			//BHD Entries add the 64bit destination address
+			destinationBytes := make([]byte, 8)
+			if c, err := directoryReader.Read(destinationBytes); err != nil || c != 8 {
 				return nil, fmt.Errorf("Could not read BHD directory entry: %v", err)
 			}
-			val := binary.LittleEndian.Uint64(unknownBytes)
+			destination := binary.LittleEndian.Uint64(destinationBytes)
+			attributes := ParseBIOSEntryAttributes(entry.DirectoryEntry.Type)
 
-			entry.DirectoryEntry.Unknown = &val
+			entry.DirectoryEntry.Destination = &destination
+			entry.DirectoryEntry.BIOSAttributes = &attributes
 		}
 
 		directory.Entries[i] = *entry
@@ -127,6 +179,78 @@ func ParseDirectory(firmwareBytes []byte, address uint32, flashMapping uint32) (
 	return &directory, nil
 }
 
+func ParseBIOSEntryAttributes(entryType uint32) BIOSEntryAttributes {
+	return BIOSEntryAttributes{
+		RegionType: uint8(entryType >> 8),
+		ResetImage: entryType&(1<<16) != 0,
+		CopyImage:  entryType&(1<<17) != 0,
+		ReadOnly:   entryType&(1<<18) != 0,
+		Compressed: entryType&(1<<19) != 0,
+		Instance:   uint8(entryType>>20) & 0xF,
+		SubProgram: uint8(entryType>>24) & 0x7,
+		RomID:      uint8(entryType>>27) & 0x3,
+		Writable:   entryType&(1<<29) != 0,
+		Reserved:   uint8(entryType>>30) & 0x3,
+	}
+}
+
+// Encodes the attributes into the upper 24 bits of a type word
+func (attributes BIOSEntryAttributes) Encode() uint32 {
+	flag := func(set bool, bit uint) uint32 {
+		if set {
+			return 1 << bit
+		}
+		return 0
+	}
+
+	return uint32(attributes.RegionType)<<8 |
+		flag(attributes.ResetImage, 16) |
+		flag(attributes.CopyImage, 17) |
+		flag(attributes.ReadOnly, 18) |
+		flag(attributes.Compressed, 19) |
+		uint32(attributes.Instance&0xF)<<20 |
+		uint32(attributes.SubProgram&0x7)<<24 |
+		uint32(attributes.RomID&0x3)<<27 |
+		flag(attributes.Writable, 29) |
+		uint32(attributes.Reserved&0x3)<<30
+}
+
+func (entry *DirectoryEntry) IsValueEntry() bool {
+	return entry.Size == ValueEntrySize
+}
+
+// Immediate value of a value entry spanning Location and Reserved
+func (entry *DirectoryEntry) Value() uint64 {
+	return uint64(entry.Reserved)<<32 | uint64(entry.Location)
+}
+
+// Lowest byte of the type word, identical for PSP and BIOS directories
+func (entry *DirectoryEntry) BaseType() uint8 {
+	return uint8(entry.Type)
+}
+
+func (entry *DirectoryEntry) SubProgram() uint8 {
+	if entry.BIOSAttributes != nil {
+		return entry.BIOSAttributes.SubProgram
+	}
+	return uint8(entry.Type >> 8)
+}
+
+func (entry *DirectoryEntry) RomID() uint8 {
+	if entry.BIOSAttributes != nil {
+		return entry.BIOSAttributes.RomID
+	}
+	return uint8(entry.Type>>16) & 0x3
+}
+
+// Returns the type word with decoded BIOS attributes applied
+func (entry *DirectoryEntry) EncodedType() uint32 {
+	if entry.BIOSAttributes == nil {
+		return entry.Type
+	}
+	return entry.Type&0xFF | entry.BIOSAttributes.Encode()
+}
+
 func (header *DirectoryHeader) Write(baseImage []byte, address uint32) error {
 	buf := new(bytes.Buffer)
 
@@ -149,7 +273,7 @@ func (entry *DirectoryEntry) Write(baseImage []byte, address uint32) error {
 	buf := new(bytes.Buffer)
 
 	binEntry := binaryDirectoryEntry{
-		Type:     entry.Type,
+		Type:     entry.EncodedType(),
 		Size:     entry.Size,
 		Location: entry.Location,
 		Reserved: entry.Reserved,
@@ -167,9 +291,9 @@ func (entry *DirectoryEntry) Write(baseImage []byte, address uint32) error {
 
 	bytesNeeded := binary.Size(binaryDirectoryEntry{})
 
-	if entry.Unknown != nil {
-		binary.Write(buf, binary.LittleEndian, *entry.Unknown)
-		bytesNeeded += binary.Size(*entry.Unknown)
+	if entry.Destination != nil {
+		binary.Write(buf, binary.LittleEndian, *entry.Destination)
+		bytesNeeded += binary.Size(*entry.Destination)
 	}
 
 	bytesCopied := copy(baseImage[address:], buf.Bytes())
@@ -181,31 +305,182 @@ func (entry *DirectoryEntry) Write(baseImage []byte, address uint32) error {
 	return nil
 }
 
+// Appends a new entry to the directory
+func (directory *Directory) AddEntry(entry Entry) error {
+	entryType := entry.DirectoryEntry.EncodedType()
+	instance := directory.instance(entryType)
+	if index := directory.findEntry(entryType, instance); index >= 0 {
+		return fmt.Errorf("Cannot add Entry: Type 0x%X instance %d already present", entryType, instance)
+	}
+
+	if directory.EntrySize() == 24 && entry.DirectoryEntry.Destination == nil {
+		destination := ^uint64(0)
+		entry.DirectoryEntry.Destination = &destination
+	}
+
+	directory.Entries = append(directory.Entries[:len(directory.Entries):len(directory.Entries)], entry)
+	directory.entriesChanged()
+	return nil
+}
+
+// Removes the entry with the given type and instance
+func (directory *Directory) RemoveEntry(entryType uint32, instance uint8) error {
+	index := directory.findEntry(entryType, instance)
+	if index < 0 {
+		return fmt.Errorf("Cannot remove Entry: Type 0x%X instance %d not found", entryType, instance)
+	}
+
+	directory.Entries = append(directory.Entries[:index:index], directory.Entries[index+1:]...)
+	directory.entriesChanged()
+	return nil
+}
+
+// Replaces the entry with the same type and instance
+func (directory *Directory) ReplaceEntry(entry Entry) error {
+	entryType := entry.DirectoryEntry.EncodedType()
+	instance := directory.instance(entryType)
+	index := directory.findEntry(entryType, instance)
+	if index < 0 {
+		return fmt.Errorf("Cannot replace Entry: Type 0x%X instance %d not found", entryType, instance)
+	}
+
+	if directory.EntrySize() == 24 && entry.DirectoryEntry.Destination == nil {
+		entry.DirectoryEntry.Destination = directory.Entries[index].DirectoryEntry.Destination
+	}
+
+	entries := make([]Entry, len(directory.Entries))
+	copy(entries, directory.Entries)
+	entries[index] = entry
+	directory.Entries = entries
+
+	directory.entriesChanged()
+	return nil
+}
+
+// PSP directories store the instance in bits 19-22, BIOS directories in bits 20-23
+func (directory *Directory) instanceShift() uint {
+	if directory.EntrySize() == 24 {
+		return 20
+	}
+	return 19
+}
+
+func (directory *Directory) instance(entryType uint32) uint8 {
+	return uint8(entryType>>directory.instanceShift()) & 0xF
+}
+
+func (directory *Directory) findEntry(entryType uint32, instance uint8) int {
+	instanceMask := uint32(0xF) << directory.instanceShift()
+	for i := range directory.Entries {
+		word := directory.Entries[i].DirectoryEntry.EncodedType()
+		if word&^instanceMask == entryType&^instanceMask && directory.instance(word) == instance {
+			return i
+		}
+	}
+	return -1
+}
+
+func (directory *Directory) entriesChanged() {
+	directory.Header.TotalEntries = uint32(len(directory.Entries))
+	directory.UpdateChecksum()
+}
+
+// A table may only grow into erased flash and never into its own entries
+func (directory *Directory) checkTableSpace(baseImage []byte, space AddressSpace) error {
+	start, inImage := space.ToRelative(directory.Location)
+	end := start + directory.Size()
+
+	if !inImage || int(end) > len(baseImage) {
+		return fmt.Errorf("BaseImage to small to insert Directory")
+	}
+
+	for _, entry := range directory.Entries {
+		if entry.DirectoryEntry.IsValueEntry() || len(entry.Raw) == 0 {
+			continue
+		}
+		entryLocation := directory.ResolveLocation(&entry.DirectoryEntry, space)
+		entryStart, inImage := space.ToRelative(entryLocation)
+		if !inImage {
+			continue
+		}
+		entryEnd := entryStart + uint32(len(entry.Raw))
+		if entryStart < end && start < entryEnd {
+			return fmt.Errorf("Directory table would overflow into entry at 0x%08X", entryLocation)
+		}
+	}
+
+	// Only check growth against a table already present in the image
+	existingEnd, found := directory.existingTableEnd(baseImage, start)
+	if !found {
+		return nil
+	}
+
+	for address := existingEnd; address < end; address++ {
+		if baseImage[address] != 0xFF {
+			return fmt.Errorf("Directory table would overflow into next structure at 0x%08X", space.FromRelative(address))
+		}
+	}
+	return nil
+}
+
+// Returns the end of the table currently present in the image at the relative address start
+func (directory *Directory) existingTableEnd(baseImage []byte, start uint32) (uint32, bool) {
+	existing := DirectoryHeader{}
+	if err := binary.Read(bytes.NewReader(baseImage[start:]), binary.LittleEndian, &existing); err != nil ||
+		existing.Cookie != directory.Header.Cookie {
+		return 0, false
+	}
+
+	end := uint64(start) + uint64(directory.HeaderSize()) + uint64(existing.TotalEntries)*uint64(directory.EntrySize())
+	if end > uint64(len(baseImage)) {
+		return 0, false
+	}
+	return uint32(end), true
+}
+
 func (directory *Directory) Write(baseImage []byte, flashMapping uint32) error {
-	if int(directory.Location) > len(baseImage) {
+	return directory.WriteInSpace(baseImage, NewAddressSpace(flashMapping, 0, baseImage))
+}
+
+// Writes table and entries into an image described by space.
+// External entries and entries without data outside of the image are skipped.
+func (directory *Directory) WriteInSpace(baseImage []byte, space AddressSpace) error {
+	start, inImage := space.ToRelative(directory.Location)
+	if !inImage {
 		return fmt.Errorf("BaseImage to small to insert Directory")
 	}
 
-	err := directory.Header.Write(baseImage, directory.Location)
-	if err != nil {
+	if err := directory.checkTableSpace(baseImage, space); err != nil {
 		return err
 	}
 
-	location := directory.Location + 0x10
+	// Nothing is written unless every entry fits
+	if err := directory.checkEntries(baseImage, space); err != nil {
+		return err
+	}
 
-	cookie := string(directory.Header.Cookie[:])
+	// Erase entries left over from a larger table
+	if existingEnd, found := directory.existingTableEnd(baseImage, start); found {
+		for address := start + directory.Size(); address < existingEnd; address++ {
+			baseImage[address] = 0xFF
+		}
+	}
 
-	if cookie == DUALPSPCOOCKIE {
-		location += 0x10
+	err := directory.Header.Write(baseImage, start)
+	if err != nil {
+		return err
 	}
 
-	for i, entry := range directory.Entries {
+	if directory.IsCombo() {
+		buf := new(bytes.Buffer)
+		binary.Write(buf, binary.LittleEndian, directory.ComboReserved)
+		copy(baseImage[start+uint32(binary.Size(directory.Header)):], buf.Bytes())
+	}
 
-		entryLength := uint32(16)
+	location := start + directory.HeaderSize()
+	entryLength := directory.EntrySize()
 
-		if entry.DirectoryEntry.Unknown != nil {
-			entryLength += 8
-		}
+	for i, entry := range directory.Entries {
 
 		entryAddress := location + uint32(i)*entryLength
 		err := entry.DirectoryEntry.Write(baseImage, entryAddress)
@@ -213,9 +488,16 @@ func (directory *Directory) Write(baseImage []byte, flashMapping uint32) error {
 			return err
 		}
 
-		entryLocation := entry.DirectoryEntry.Location
+		if entry.DirectoryEntry.IsValueEntry() || entry.External {
+			continue
+		}
+
+		entryLocation, inImage := space.ToRelative(directory.ResolveLocation(&entry.DirectoryEntry, space))
+		if !inImage {
+			continue
+		}
 
-		err = entry.Write(baseImage, entryLocation&^flashMapping)
+		err = entry.Write(baseImage, entryLocation)
 		if err != nil {
 			return err
 		}
@@ -223,6 +505,33 @@ func (directory *Directory) Write(baseImage []byte, flashMapping uint32) error {
 	return nil
 }
 
+// Checks that the data of every entry can be written at its location
+func (directory *Directory) checkEntries(baseImage []byte, space AddressSpace) error {
+	for _, entry := range directory.Entries {
+		if entry.DirectoryEntry.IsValueEntry() || entry.External {
+			continue
+		}
+
+		if uint32(len(entry.Raw)) > entry.DirectoryEntry.Size {
+			return fmt.Errorf("Entry at 0x%08X outgrew its space (0x%X > 0x%X bytes): Relocate first", entry.DirectoryEntry.Location, len(entry.Raw), entry.DirectoryEntry.Size)
+		}
+
+		resolved := directory.ResolveLocation(&entry.DirectoryEntry, space)
+		entryLocation, inImage := space.ToRelative(resolved)
+		if !inImage {
+			if len(entry.Raw) != 0 {
+				return fmt.Errorf("Cannot write Entry: 0x%08X is not part of the image", resolved)
+			}
+			continue
+		}
+
+		if uint64(entryLocation)+uint64(len(entry.Raw)) > uint64(len(baseImage)) {
+			return fmt.Errorf("Cannot write Entry: 0x%08X exceeds the image", resolved)
+		}
+	}
+	return nil
+}
+
 func fletcher32(data []byte) uint32 {
 	c0 := 0xFFFF
 	c1 := 0xFFFF
@@ -240,8 +549,42 @@ func fletcher32(data []byte) uint32 {
 	return uint32((c1 << 16) | c0)
 }
 
+// Size of the header including the reserved words of combo directories
+func (directory *Directory) HeaderSize() uint32 {
+	if directory.IsCombo() {
+		return 0x20
+	}
+	return 0x10
+}
+
+// BIOS directory entries carry an additional 64bit destination
+func (directory *Directory) EntrySize() uint32 {
+	switch string(directory.Header.Cookie[:]) {
+	case BHDCOOCKIE, SECONDBHDCOOCKIE:
+		return 24
+	default:
+		return 16
+	}
+}
+
+// Size of header and entry table
+func (directory *Directory) Size() uint32 {
+	return directory.HeaderSize() + uint32(len(directory.Entries))*directory.EntrySize()
+}
+
 // Validates the Directory Checksum and return the actual value
 func (directory *Directory) ValidateChecksum() (valid bool, actual uint32) {
+	sum := directory.CalculateChecksum()
+	return sum == directory.Header.Checksum, sum
+}
+
+// Recalculates the Directory Checksum and stores it in the header
+func (directory *Directory) UpdateChecksum() {
+	directory.Header.Checksum = directory.CalculateChecksum()
+}
+
+// Fletcher-32 over everything following the checksum field
+func (directory *Directory) CalculateChecksum() uint32 {
 	uint32Buffer := make([]byte, 4)
 	buf := new(bytes.Buffer)
 
@@ -253,14 +596,13 @@ func (directory *Directory) ValidateChecksum() (valid bool, actual uint32) {
 	binary.LittleEndian.PutUint32(uint32Buffer, directory.Header.Reserved)
 	buf.Write(uint32Buffer)
 
-	cookie := string(directory.Header.Cookie[:])
-	if cookie == DUALPSPCOOCKIE {
-		buf.Write(bytes.Repeat([]byte{0}, 16))
+	if directory.IsCombo() {
+		binary.Write(buf, binary.LittleEndian, directory.ComboReserved)
 	}
 
 	for _, entry := range directory.Entries {
 
-		binary.LittleEndian.PutUint32(uint32Buffer, entry.DirectoryEntry.Type)
+		binary.LittleEndian.PutUint32(uint32Buffer, entry.DirectoryEntry.EncodedType())
 		buf.Write(uint32Buffer)
 
 		binary.LittleEndian.PutUint32(uint32Buffer, entry.DirectoryEntry.Size)
@@ -272,13 +614,16 @@ func (directory *Directory) ValidateChecksum() (valid bool, actual uint32) {
 		binary.LittleEndian.PutUint32(uint32Buffer, entry.DirectoryEntry.Reserved)
 		buf.Write(uint32Buffer)
 
-		if cookie == BHDCOOCKIE || cookie == SECONDBHDCOOCKIE {
+		if directory.EntrySize() == 24 {
+			destination := ^uint64(0)
+			if entry.DirectoryEntry.Destination != nil {
+				destination = *entry.DirectoryEntry.Destination
+			}
 			uint64Buffer := make([]byte, 8)
-			binary.LittleEndian.PutUint64(uint64Buffer, *entry.DirectoryEntry.Unknown)
+			binary.LittleEndian.PutUint64(uint64Buffer, destination)
 			buf.Write(uint64Buffer)
 		}
 	}
 
-	sum := fletcher32(buf.Bytes())
-	return sum == directory.Header.Checksum, sum
+	return fletcher32(buf.Bytes())
 }
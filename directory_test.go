@@ -1,6 +1,7 @@
 package amdfw
 
 import (
+	"bytes"
 	"fmt"
 	"github.com/stretchr/testify/assert"
 	"testing"
@@ -167,25 +168,25 @@ var (
 	testBHDDirectoryHeaderBytes = testBHDDirectoryBytes[0:16]
 	testBHDDirectoryEntryBytes  = testBHDDirectoryBytes[16 : 16+4*6]
 
-	testBHDDirectoryHeader     = DirectoryHeader{Cookie: [4]uint8{0x24, 0x42, 0x48, 0x44}, Checksum: 0x9e63f852, TotalEntries: 0xb, Reserved: 0x41c}
-	testBHDDirEntryUnknown0xFF = uint64(0xffffffffffffffff)
-	testBHDDirEntryKnown0xA2   = uint64(0xa200000)
-	testBHDDirEntryKnown0x9E   = uint64(0x9e00000)
+	testBHDDirectoryHeader         = DirectoryHeader{Cookie: [4]uint8{0x24, 0x42, 0x48, 0x44}, Checksum: 0x9e63f852, TotalEntries: 0xb, Reserved: 0x41c}
+	testBHDDirEntryNoDestination   = uint64(0xffffffffffffffff)
+	testBHDDirEntryDestination0xA2 = uint64(0xa200000)
+	testBHDDirEntryDestination0x9E = uint64(0x9e00000)
 
 	testBHDDirectory = Directory{
 		Header: testBHDDirectoryHeader,
 		Entries: []Entry{
-			{DirectoryEntry: DirectoryEntry{Type: 0x60, Size: 0x2000, Location: 0xff1c2000, Reserved: 0x0, Unknown: &testBHDDirEntryUnknown0xFF}},
-			{DirectoryEntry: DirectoryEntry{Type: 0x200060, Size: 0x2000, Location: 0xff1c4000, Reserved: 0x0, Unknown: &testBHDDirEntryUnknown0xFF}},
-			{DirectoryEntry: DirectoryEntry{Type: 0x68, Size: 0x2000, Location: 0xff1c6000, Reserved: 0x0, Unknown: &testBHDDirEntryUnknown0xFF}},
-			{DirectoryEntry: DirectoryEntry{Type: 0x200068, Size: 0x2000, Location: 0xff1c8000, Reserved: 0x0, Unknown: &testBHDDirEntryUnknown0xFF}},
-			{DirectoryEntry: DirectoryEntry{Type: 0x61, Size: 0x0, Location: 0x0, Reserved: 0x0, Unknown: &testBHDDirEntryKnown0xA2}},
-			{DirectoryEntry: DirectoryEntry{Type: 0x30062, Size: 0x200000, Location: 0xffe00000, Reserved: 0x0, Unknown: &testBHDDirEntryKnown0x9E}},
-			{DirectoryEntry: DirectoryEntry{Type: 0x100064, Size: 0x3c40, Location: 0xff1ca000, Reserved: 0x0, Unknown: &testBHDDirEntryUnknown0xFF}},
-			{DirectoryEntry: DirectoryEntry{Type: 0x100065, Size: 0x330, Location: 0xff1cdd00, Reserved: 0x0, Unknown: &testBHDDirEntryUnknown0xFF}},
-			{DirectoryEntry: DirectoryEntry{Type: 0x400064, Size: 0x4610, Location: 0xff1ce100, Reserved: 0x0, Unknown: &testBHDDirEntryUnknown0xFF}},
-			{DirectoryEntry: DirectoryEntry{Type: 0x400065, Size: 0x320, Location: 0xff1d2800, Reserved: 0x0, Unknown: &testBHDDirEntryUnknown0xFF}},
-			{DirectoryEntry: DirectoryEntry{Type: 0x70, Size: 0x400, Location: 0xff641000, Reserved: 0x0, Unknown: &testBHDDirEntryUnknown0xFF}},
+			{DirectoryEntry: DirectoryEntry{Type: 0x60, Size: 0x2000, Location: 0xff1c2000, Reserved: 0x0, Destination: &testBHDDirEntryNoDestination}},
+			{DirectoryEntry: DirectoryEntry{Type: 0x200060, Size: 0x2000, Location: 0xff1c4000, Reserved: 0x0, Destination: &testBHDDirEntryNoDestination}},
+			{DirectoryEntry: DirectoryEntry{Type: 0x68, Size: 0x2000, Location: 0xff1c6000, Reserved: 0x0, Destination: &testBHDDirEntryNoDestination}},
+			{DirectoryEntry: DirectoryEntry{Type: 0x200068, Size: 0x2000, Location: 0xff1c8000, Reserved: 0x0, Destination: &testBHDDirEntryNoDestination}},
+			{DirectoryEntry: DirectoryEntry{Type: 0x61, Size: 0x0, Location: 0x0, Reserved: 0x0, Destination: &testBHDDirEntryDestination0xA2}},
+			{DirectoryEntry: DirectoryEntry{Type: 0x30062, Size: 0x200000, Location: 0xffe00000, Reserved: 0x0, Destination: &testBHDDirEntryDestination0x9E}},
+			{DirectoryEntry: DirectoryEntry{Type: 0x100064, Size: 0x3c40, Location: 0xff1ca000, Reserved: 0x0, Destination: &testBHDDirEntryNoDestination}},
+			{DirectoryEntry: DirectoryEntry{Type: 0x100065, Size: 0x330, Location: 0xff1cdd00, Reserved: 0x0, Destination: &testBHDDirEntryNoDestination}},
+			{DirectoryEntry: DirectoryEntry{Type: 0x400064, Size: 0x4610, Location: 0xff1ce100, Reserved: 0x0, Destination: &testBHDDirEntryNoDestination}},
+			{DirectoryEntry: DirectoryEntry{Type: 0x400065, Size: 0x320, Location: 0xff1d2800, Reserved: 0x0, Destination: &testBHDDirEntryNoDestination}},
+			{DirectoryEntry: DirectoryEntry{Type: 0x70, Size: 0x400, Location: 0xff641000, Reserved: 0x0, Destination: &testBHDDirEntryNoDestination}},
 		},
 		Location: testBHDDirBase - DefaultFlashMapping,
 	}
@@ -285,14 +286,46 @@ func TestParseBHDDirectory(t *testing.T) {
 	assert.Equal(t, testBHDDirBase-DefaultFlashMapping, dir.Location)
 
 	for i := 0; i < len(dir.Entries); i++ {
-		assert.Equal(t, *testBHDDirectory.Entries[i].DirectoryEntry.Unknown, *dir.Entries[i].DirectoryEntry.Unknown, fmt.Sprint("Entries padding does not match: ", i))
+		assert.Equal(t, *testBHDDirectory.Entries[i].DirectoryEntry.Destination, *dir.Entries[i].DirectoryEntry.Destination, fmt.Sprint("Entries destination does not match: ", i))
 
-		// Whipe unknown Pointer
-		dir.Entries[i].DirectoryEntry.Unknown = testBHDDirectory.Entries[i].DirectoryEntry.Unknown
+		assert.Equal(t, ParseBIOSEntryAttributes(testBHDDirectory.Entries[i].DirectoryEntry.Type), *dir.Entries[i].DirectoryEntry.BIOSAttributes)
+
+		// Whipe destination and attribute Pointer
+		dir.Entries[i].DirectoryEntry.Destination = testBHDDirectory.Entries[i].DirectoryEntry.Destination
+		dir.Entries[i].DirectoryEntry.BIOSAttributes = nil
 		assert.Equal(t, testBHDDirectory.Entries[i].DirectoryEntry, dir.Entries[i].DirectoryEntry)
 	}
 }
 
+func TestParseBIOSEntryAttributes(t *testing.T) {
+	resetImage := ParseBIOSEntryAttributes(0x30062)
+	assert.Equal(t, BIOSEntryAttributes{ResetImage: true, CopyImage: true}, resetImage)
+	assert.Equal(t, uint32(0x30000), resetImage.Encode())
+
+	apcb := ParseBIOSEntryAttributes(0x400064)
+	assert.Equal(t, BIOSEntryAttributes{Instance: 4}, apcb)
+
+	all := ParseBIOSEntryAttributes(0xFFFFFF00)
+	assert.Equal(t, uint32(0xFFFFFF00), all.Encode())
+}
+
+func TestDirectoryEntry_WriteBIOSAttributes(t *testing.T) {
+	destination := uint64(0x9e00000)
+	entry := DirectoryEntry{
+		Type:           0x62,
+		Size:           0x200000,
+		Location:       0xffe00000,
+		BIOSAttributes: &BIOSEntryAttributes{ResetImage: true, CopyImage: true},
+		Destination:    &destination,
+	}
+
+	baseImage := make([]byte, 24)
+	err := entry.Write(baseImage, 0)
+
+	assert.Nil(t, err)
+	assert.Equal(t, testBHDDirectoryBytes[16+5*24:16+6*24], baseImage)
+}
+
 func TestDirectory_ValidateChecksum(t *testing.T) {
 	valid, actual := testPSPDirectory.ValidateChecksum()
 
@@ -314,6 +347,17 @@ func TestDirectory_ValidateChecksumBHD(t *testing.T) {
 	assert.Equal(t, testBHDDirectory.Header.Checksum, actual)
 }
 
+func TestDirectory_UpdateChecksum(t *testing.T) {
+	for _, fixture := range []Directory{testPSPDirectory, test2PSPDirectory, testBHDDirectory} {
+		directory := fixture
+		directory.Header.Checksum = 0
+
+		directory.UpdateChecksum()
+
+		assert.Equal(t, fixture.Header.Checksum, directory.Header.Checksum)
+	}
+}
+
 func TestDirectory_Write(t *testing.T) {
 	baseImage := make([]byte, testImage16MB)
 	expectedImage := make([]byte, testImage16MB)
@@ -387,3 +431,142 @@ func TestDirectory_Write2PSP(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, expectedImage, baseImage)
 }
+
+func TestDirectory_AddEntry(t *testing.T) {
+	directory := testPSPMiniDirectory
+
+	err := directory.AddEntry(Entry{DirectoryEntry: DirectoryEntry{Type: 0x1, Size: 0x8000, Location: 0xff181000}})
+
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(2), directory.Header.TotalEntries)
+	assert.Equal(t, 1, len(testPSPMiniDirectory.Entries))
+
+	valid, _ := directory.ValidateChecksum()
+	assert.True(t, valid)
+
+	imageBytes := make([]byte, testImage16MB)
+	err = directory.Write(imageBytes, DefaultFlashMapping)
+	assert.Nil(t, err)
+
+	parsed, err := ParseDirectory(imageBytes, testPSPDirBase, DefaultFlashMapping)
+	assert.Nil(t, err)
+	assert.Equal(t, directory.Header, parsed.Header)
+	assert.Equal(t, directory.Entries[1].DirectoryEntry, parsed.Entries[1].DirectoryEntry)
+}
+
+func TestDirectory_AddEntryDuplicate(t *testing.T) {
+	directory := testPSPMiniDirectory
+
+	err := directory.AddEntry(Entry{DirectoryEntry: DirectoryEntry{Type: 0x0}})
+
+	assert.EqualError(t, err, "Cannot add Entry: Type 0x0 instance 0 already present")
+	assert.Equal(t, uint32(1), directory.Header.TotalEntries)
+}
+
+func TestDirectory_AddEntryBHD(t *testing.T) {
+	directory := testBHDDirectory
+
+	err := directory.AddEntry(Entry{DirectoryEntry: DirectoryEntry{Type: 0x500064, Size: 0x1000, Location: 0xff1d3000}})
+
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(12), directory.Header.TotalEntries)
+	assert.Equal(t, uint64(0xFFFFFFFFFFFFFFFF), *directory.Entries[11].DirectoryEntry.Destination)
+	assert.Equal(t, uint32(16+12*24), directory.Size())
+}
+
+func TestDirectory_RemoveEntry(t *testing.T) {
+	directory := testBHDDirectory
+
+	err := directory.RemoveEntry(0x64, 4)
+
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(10), directory.Header.TotalEntries)
+	assert.Equal(t, 11, len(testBHDDirectory.Entries))
+	assert.Equal(t, uint32(0x400065), directory.Entries[8].DirectoryEntry.Type)
+
+	valid, _ := directory.ValidateChecksum()
+	assert.True(t, valid)
+
+	err = directory.RemoveEntry(0x64, 4)
+	assert.EqualError(t, err, "Cannot remove Entry: Type 0x64 instance 4 not found")
+}
+
+func TestDirectory_ReplaceEntry(t *testing.T) {
+	directory := testPSPDirectory
+	replacement := Entry{DirectoryEntry: DirectoryEntry{Type: 0x108, Size: 0x15000, Location: 0xff300000}}
+
+	err := directory.ReplaceEntry(replacement)
+
+	assert.Nil(t, err)
+	assert.Equal(t, replacement.DirectoryEntry, directory.Entries[8].DirectoryEntry)
+	assert.Equal(t, uint32(0x14000), testPSPDirectory.Entries[8].DirectoryEntry.Size)
+	assert.Equal(t, testPSPDirectory.Header.TotalEntries, directory.Header.TotalEntries)
+
+	valid, _ := directory.ValidateChecksum()
+	assert.True(t, valid)
+}
+
+func TestDirectory_WriteOverflow(t *testing.T) {
+	imageBytes := make([]byte, testImage16MB)
+	copy(imageBytes[testPSPDirBase-DefaultFlashMapping:], testPSPMiniDirectoryBytes)
+
+	directory := testPSPMiniDirectory
+	directory.AddEntry(Entry{DirectoryEntry: DirectoryEntry{Type: 0x1, Size: 0x8000, Location: 0xff181000}})
+
+	err := directory.Write(imageBytes, DefaultFlashMapping)
+	assert.EqualError(t, err, "Directory table would overflow into next structure at 0x00101020")
+
+	// Erased flash may be used
+	copy(imageBytes[testPSPDirBase-DefaultFlashMapping+0x20:], bytes.Repeat([]byte{0xFF}, 0x10))
+	err = directory.Write(imageBytes, DefaultFlashMapping)
+	assert.Nil(t, err)
+}
+
+func TestDirectory_WriteShrunk(t *testing.T) {
+	imageBytes := make([]byte, testImage16MB)
+	copy(imageBytes[testBHDDirectory.Location:], testBHDDirectoryBytes)
+
+	directory := testBHDDirectory
+	directory.RemoveEntry(0x70, 0)
+
+	err := directory.Write(imageBytes, DefaultFlashMapping)
+	assert.Nil(t, err)
+
+	end := directory.Location + directory.Size()
+	assert.Equal(t, bytes.Repeat([]byte{0xFF}, 24), imageBytes[end:end+24])
+
+	parsed, err := ParseDirectory(imageBytes, testBHDDirBase, DefaultFlashMapping)
+	assert.Nil(t, err)
+	assert.Equal(t, 10, len(parsed.Entries))
+	valid, _ := parsed.ValidateChecksum()
+	assert.True(t, valid)
+}
+
+func TestDirectory_WriteOutgrownLeavesImage(t *testing.T) {
+	directory := Directory{Location: 0x1000}
+	copy(directory.Header.Cookie[:], PSPCOOCKIE)
+	assert.Nil(t, directory.AddEntry(Entry{DirectoryEntry: DirectoryEntry{Type: 0x1, Size: 0x10, Location: 0x2000}, Raw: bytes.Repeat([]byte{0x1}, 0x10)}))
+	assert.Nil(t, directory.AddEntry(Entry{DirectoryEntry: DirectoryEntry{Type: 0x3, Size: 0x10, Location: 0x3000}, Raw: bytes.Repeat([]byte{0x3}, 0x20)}))
+
+	baseImage := bytes.Repeat([]byte{0xFF}, 0x10000)
+	untouched := bytes.Repeat([]byte{0xFF}, 0x10000)
+
+	err := directory.Write(baseImage, 0)
+
+	assert.EqualError(t, err, "Entry at 0x00003000 outgrew its space (0x20 > 0x10 bytes): Relocate first")
+	assert.Equal(t, untouched, baseImage)
+}
+
+func TestDirectory_WriteEntryExceedingImage(t *testing.T) {
+	directory := Directory{Location: 0x1000}
+	copy(directory.Header.Cookie[:], PSPCOOCKIE)
+	assert.Nil(t, directory.AddEntry(Entry{DirectoryEntry: DirectoryEntry{Type: 0x1, Size: 0x20, Location: 0xFFF0}, Raw: bytes.Repeat([]byte{0x1}, 0x20)}))
+
+	baseImage := bytes.Repeat([]byte{0xFF}, 0x10000)
+	untouched := bytes.Repeat([]byte{0xFF}, 0x10000)
+
+	err := directory.Write(baseImage, 0)
+
+	assert.EqualError(t, err, "Cannot write Entry: 0x0000FFF0 exceeds the image")
+	assert.Equal(t, untouched, baseImage)
+}
@@ -0,0 +1,175 @@
+package amdfw
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+)
+
+// All cookies starting a directory
+var directoryCookies = []string{PSPCOOCKIE, DUALPSPCOOCKIE, SECONDPSPCOOCKIE, BHDCOOCKIE, DUALBHDCOOCKIE, SECONDBHDCOOCKIE}
+
+// Candidates for the mapping of the flash into the address space
+var flashMappings = []uint32{
+	DefaultFlashMapping + 0x000000, //16M
+	DefaultFlashMapping + 0x800000, // 8M
+	DefaultFlashMapping + 0xB00000, // 4M
+	DefaultFlashMapping + 0xD00000, // 2M
+	DefaultFlashMapping + 0xE00000, // 1M
+	DefaultFlashMapping + 0xE80000, // 512K
+}
+
+// Scans the whole image for directory cookies (slow).
+// Only directories with a valid checksum are returned, sorted by their offset in firmwareBytes.
+func FindDirectories(firmwareBytes []byte) []uint32 {
+	var offsets []uint32
+	for _, cookie := range directoryCookies {
+		for start := 0; start < len(firmwareBytes); {
+			index := bytes.Index(firmwareBytes[start:], []byte(cookie))
+			if index < 0 {
+				break
+			}
+			offset := uint32(start + index)
+			start += index + 1
+
+			directory, err := ParseDirectory(firmwareBytes, offset, 0)
+			if err != nil || len(directory.Entries) == 0 {
+				continue
+			}
+			if valid, _ := directory.ValidateChecksum(); valid {
+				offsets = append(offsets, offset)
+			}
+		}
+	}
+
+	sort.Slice(offsets, func(i, j int) bool {
+		return offsets[i] < offsets[j]
+	})
+	return offsets
+}
+
+// Guesses the flash mapping from the locations used by the directories.
+// Mappings are scored by how many entries land inside the image and how many
+// directory pointers hit one of the found directories.
+func InferFlashMapping(firmwareBytes []byte, directories []*Directory) uint32 {
+	return inferFlashMapping(firmwareBytes, directories, 0)
+}
+
+func inferFlashMapping(firmwareBytes []byte, directories []*Directory, baseOffset uint32) uint32 {
+	imageEnd := uint64(baseOffset) + uint64(len(firmwareBytes))
+
+	found := make(map[uint32]bool)
+	for _, directory := range directories {
+		found[directory.Location] = true
+	}
+
+	best := DefaultFlashMapping
+	bestScore := -1
+	for _, mapping := range flashMappings {
+		space := NewAddressSpace(mapping, baseOffset, firmwareBytes)
+		score := 0
+		for _, directory := range directories {
+			for _, entry := range directory.Entries {
+				if entry.DirectoryEntry.IsValueEntry() {
+					continue
+				}
+				location := directory.ResolveLocation(&entry.DirectoryEntry, space)
+				if space.Contains(location, entry.DirectoryEntry.Size) {
+					score++
+				}
+				if (directory.IsCombo() || isDirectoryType(entry.DirectoryEntry.Type)) && found[location] {
+					score += 2
+				}
+			}
+		}
+
+		// Flash is mapped right below 4GB, so the image size breaks ties
+		if score > bestScore || (score == bestScore && uint64(mapping)+imageEnd == 1<<32) {
+			best = mapping
+			bestScore = score
+		}
+	}
+	return best
+}
+
+// Builds an Image from the directories found by scanning the flash.
+// Used on images without Firmware Entry Table. The FET of the returned Image is nil.
+// Directories not referenced by any other directory become the root of a ROM.
+func ParseImageWithoutFET(firmwareBytes []byte) (*Image, error) {
+	return parseImageWithoutFET(firmwareBytes, ParseOptions{})
+}
+
+func parseImageWithoutFET(firmwareBytes []byte, options ParseOptions) (*Image, error) {
+	baseOffset := options.BaseOffset
+
+	offsets := FindDirectories(firmwareBytes)
+	if len(offsets) == 0 {
+		return nil, fmt.Errorf("No directories found")
+	}
+
+	var directories []*Directory
+	unmapped := NewAddressSpace(0, baseOffset, firmwareBytes)
+	for _, offset := range offsets {
+		directory, err := ParseDirectoryInSpace(firmwareBytes, unmapped.FromRelative(offset), unmapped)
+		if err != nil {
+			continue
+		}
+		directories = append(directories, directory)
+	}
+
+	mapping := inferFlashMapping(firmwareBytes, directories, baseOffset)
+	space := NewAddressSpace(mapping, baseOffset, firmwareBytes)
+
+	referenced := make(map[uint32]bool)
+	for _, directory := range directories {
+		for _, entry := range directory.Entries {
+			location := directory.ResolveLocation(&entry.DirectoryEntry, space)
+			if directory.IsCombo() || isDirectoryType(entry.DirectoryEntry.Type) {
+				referenced[location] = true
+			} else if isSlotType(entry.DirectoryEntry.Type) {
+				if target, _, err := parseSlotTarget(firmwareBytes, location, space); err == nil {
+					referenced[target] = true
+				}
+			}
+		}
+	}
+
+	image := Image{
+		FlashMapping:      &mapping,
+		BaseOffset:        baseOffset,
+		MaxDirectoryDepth: options.maxDirectoryDepth(),
+	}
+
+	var errs []error
+	for _, directory := range directories {
+		if referenced[directory.Location] {
+			continue
+		}
+
+		romType := PSPRom
+		switch string(directory.Header.Cookie[:]) {
+		case BHDCOOCKIE, DUALBHDCOOCKIE, SECONDBHDCOOCKIE:
+			romType = BHDRom
+		}
+
+		location := directory.Location
+		rom, err := parseDirectoryRom(firmwareBytes, &location, romType, space, options.maxDirectoryDepth())
+		if err != nil {
+			errs = append(errs, fmt.Errorf("Could not parse %s rom at 0x%08X: %w", romType, location, err))
+		}
+		if rom != nil {
+			image.Roms = append(image.Roms, rom)
+		}
+	}
+
+	if len(errs) != 0 {
+		return &image, RomErrors(errs)
+	}
+	return &image, nil
+}
+
+// Entries pointing to a level 2 directory
+func isDirectoryType(entryType uint32) bool {
+	baseType := uint8(entryType)
+	return baseType == 0x40 || baseType == 0x70
+}
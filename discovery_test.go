@@ -0,0 +1,62 @@
+package amdfw
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// Image with a $PSP directory pointing to a $PL2 directory and an erased FET
+func mockImageWithoutFET(t *testing.T) []byte {
+	image, imageBytes := mockAllocatorImage()
+
+	imageBytes, err := image.Write(imageBytes)
+	assert.Nil(t, err)
+
+	for i := FETDefaultOffset; i < FETDefaultOffset+0x20; i++ {
+		imageBytes[i] = 0xFF
+	}
+	return imageBytes
+}
+
+func TestFindDirectories(t *testing.T) {
+	imageBytes := mockImageWithoutFET(t)
+	// Cookie without valid checksum
+	copy(imageBytes[0x200000:], []byte{'$', 'P', 'S', 'P', 0, 0, 0, 0, 1, 0, 0, 0})
+
+	offsets := FindDirectories(imageBytes)
+
+	assert.Equal(t, []uint32{0x101000, 0x104000}, offsets)
+}
+
+func TestInferFlashMapping(t *testing.T) {
+	imageBytes := mockImageWithoutFET(t)
+
+	pspDirectory, err := ParseDirectory(imageBytes, 0x101000, 0)
+	assert.Nil(t, err)
+
+	assert.Equal(t, DefaultFlashMapping, InferFlashMapping(imageBytes, []*Directory{pspDirectory}))
+	assert.Equal(t, DefaultFlashMapping+0x800000, InferFlashMapping(imageBytes[:0x800000], nil))
+}
+
+func TestParseImageWithoutFET(t *testing.T) {
+	imageBytes := mockImageWithoutFET(t)
+
+	image, err := ParseImage(imageBytes)
+
+	assert.Nil(t, err)
+	assert.Nil(t, image.FET)
+	assert.Equal(t, DefaultFlashMapping, *image.FlashMapping)
+	assert.Equal(t, 1, len(image.Roms))
+	assert.Equal(t, PSPRom, image.Roms[0].Type)
+	assert.Equal(t, 2, len(image.Roms[0].Directories))
+	assert.Equal(t, uint32(0x101000), image.Roms[0].Directories[0].Location)
+	assert.Equal(t, uint32(0x104000), image.Roms[0].Directories[1].Location)
+	assert.Equal(t, imageBytes[0x102000:0x103000], image.Roms[0].Directories[0].Entries[0].Raw)
+}
+
+func TestParseImageWithoutFETEmpty(t *testing.T) {
+	image, err := ParseImage(make([]byte, 0x1000))
+
+	assert.EqualError(t, err, "Could not parse Image: No FirmwareTable found: No directories found")
+	assert.Nil(t, image)
+}
@@ -13,9 +13,12 @@ type (
 		Header         *EntryHeader
 		Raw            []byte
 		Signature      []byte
+		PublicKey      *PublicKey
 		Comment        []string
 		TypeInfo       *TypeInfo
 		Version        string
+		// Located outside of the parsed bytes, Raw is not available
+		External bool
 	}
 
 	EntryHeader struct {
@@ -31,7 +34,7 @@ type (
 		IsCompressed   uint32     // 0x48
 		Unknown4C      uint32     // 0x4C
 		FullSize       uint32     // 0x50
-		Unknown54      uint32     // 0x54
+		ZlibSize       uint32     // 0x54
 		Unknown58      [0x08]byte // 0x58
 		Version        [0x04]byte // 0x60
 		Unknown64      uint32     // 0x64
@@ -60,6 +63,16 @@ type (
 var knownTypes = pspentries.Types()
 
 func ParseEntry(firmwareBytes []byte, directoryEntry DirectoryEntry, flashMapping uint32) (*Entry, error) {
+	return ParseEntryInSpace(firmwareBytes, directoryEntry, NewAddressSpace(flashMapping, 0, firmwareBytes))
+}
+
+// Parses an entry of an image described by space. The Location of the entry must be physical.
+func ParseEntryInSpace(firmwareBytes []byte, directoryEntry DirectoryEntry, space AddressSpace) (*Entry, error) {
+	return parseEntryAt(firmwareBytes, directoryEntry, directoryEntry.Location, space)
+}
+
+// Parses an entry whose data is located at the already resolved address
+func parseEntryAt(firmwareBytes []byte, directoryEntry DirectoryEntry, address uint32, space AddressSpace) (*Entry, error) {
 	entry := Entry{
 		DirectoryEntry: directoryEntry,
 	}
@@ -67,47 +80,53 @@ func ParseEntry(firmwareBytes []byte, directoryEntry DirectoryEntry, flashMappin
 	/**
 	 *	Typechecking
 	 */
-	for _, knownType := range knownTypes {
-		if knownType.Type == directoryEntry.Type {
-			name := knownType.Name
-			if name == "" {
-				name = knownType.ProposedName
-			}
-			info := TypeInfo{
-				Name:    name,
-				Comment: knownType.Comment,
-			}
-			entry.TypeInfo = &info
-			break
-		}
-	}
+	entry.TypeInfo = lookupEntryTypeInfo(directoryEntry)
 
 	if entry.TypeInfo == nil {
 		errorAndComment(&entry, fmt.Errorf("Unknown Type: 0x%08X", directoryEntry.Type))
 	}
 
+	if directoryEntry.IsValueEntry() {
+		return &entry, nil
+	}
+
 	/**
 	 * Raw Data logic
 	 */
 
-	location := directoryEntry.Location
 	size := directoryEntry.Size
 
-	if location >= flashMapping {
-		location -= flashMapping
-	}
-
-	if int(location) > len(firmwareBytes) {
-		return errorAndComment(&entry, fmt.Errorf("Not a parsable Entry: Location out of bounds (0x%08X)", location))
+	location, inImage := space.ToRelative(address)
+	if !inImage {
+		// Partial images do not contain everything referenced
+		if space.BaseOffset != 0 {
+			entry.External = true
+			entry.Comment = append(entry.Comment, fmt.Sprintf("External: 0x%08X is not part of the image", space.ToFlashOffset(address)))
+			return &entry, nil
+		}
+		return errorAndComment(&entry, fmt.Errorf("Not a parsable Entry: Location out of bounds (0x%08X)", space.ToFlashOffset(address)))
 	}
 
-	if int(size) > len(firmwareBytes)-int(location) {
+	if !space.Contains(address, size) || int(location+size) > len(firmwareBytes) {
 		return errorAndComment(&entry, fmt.Errorf("Not a parsable Entry: Size to big (0x%08X)", size))
 	}
 
 	entryBytes := firmwareBytes[location : location+size]
 	entry.Raw = entryBytes
 
+	/**
+	 * Key tokens do not carry a header
+	 */
+
+	if IsPublicKeyType(directoryEntry.Type) {
+		key, err := ParsePublicKey(entryBytes)
+		if err != nil {
+			return errorAndComment(&entry, fmt.Errorf("Not a parsable Public Key: %v", err))
+		}
+		entry.PublicKey = key
+		return &entry, nil
+	}
+
 	/**
 	 * Header Parsing
 	 */
@@ -116,7 +135,11 @@ func ParseEntry(firmwareBytes []byte, directoryEntry DirectoryEntry, flashMappin
 		return errorAndComment(&entry, fmt.Errorf("Not a parsable Entry: Entry to small for header parsing: (0x%08X) bytes", size))
 	}
 
-	headerBytes := entryBytes[:0x100]
+	// Entries without size still get their header checked, which must not run past the image
+	if len(firmwareBytes)-int(location) < 0x100 {
+		return errorAndComment(&entry, fmt.Errorf("Not a parsable Entry: Header exceeds the image at 0x%08X", space.ToFlashOffset(address)))
+	}
+	headerBytes := firmwareBytes[location : location+0x100]
 
 	if allOneValue(headerBytes) {
 		return errorAndComment(&entry, fmt.Errorf("Not a parsable Entry: All Fields are 0x%02X", headerBytes[1]))
@@ -153,6 +176,31 @@ func ParseEntry(firmwareBytes []byte, directoryEntry DirectoryEntry, flashMappin
 	return &entry, nil
 }
 
+// Some types are listed as full word. Others only by base type, their upper bits
+// carry subprogram, rom ID and BIOS attributes.
+func lookupEntryTypeInfo(directoryEntry DirectoryEntry) *TypeInfo {
+	if typeInfo := lookupTypeInfo(directoryEntry.Type); typeInfo != nil {
+		return typeInfo
+	}
+	return lookupTypeInfo(uint32(directoryEntry.BaseType()))
+}
+
+func lookupTypeInfo(entryType uint32) *TypeInfo {
+	for _, knownType := range knownTypes {
+		if knownType.Type == entryType {
+			name := knownType.Name
+			if name == "" {
+				name = knownType.ProposedName
+			}
+			return &TypeInfo{
+				Name:    name,
+				Comment: knownType.Comment,
+			}
+		}
+	}
+	return nil
+}
+
 func (entry Entry) Write(baseImage []byte, address uint32) error {
 	copied := copy(baseImage[address:], entry.Raw)
 
@@ -177,7 +177,7 @@ var (
 		IsSigned:       1,
 		SigFingerprint: [16]byte{0x27, 0x93, 0x94, 0xca, 0xc2, 0xc2, 0x4e, 0xfb, 0x97, 0xae, 0xda, 0x24, 0x1d, 0x31, 0xc8, 0xde},
 		Version:        [4]byte{0x1, 0x15, 0x5, 0x17},
-		Unknown54:      0x2cf,
+		ZlibSize:       0x2cf,
 		Unknown64:      0x424C5A50,
 		Unknown68:      0x100,
 		UnknownA0:      0x25,
@@ -319,3 +319,57 @@ func TestEntry_Write(t *testing.T) {
 	assert.Equal(t, expectedImage, baseImage)
 
 }
+
+func TestParseEntryBaseType(t *testing.T) {
+	imageBytes := make([]byte, testImage16MB)
+	copy(imageBytes[testDirectoryEntry.Location-DefaultFlashMapping:], entryBytes)
+
+	// Types listed as full word keep their name
+	directoryEntry := testDirectoryEntry
+	directoryEntry.Type = 0x108
+	entry, _ := ParseEntry(imageBytes, directoryEntry, DefaultFlashMapping)
+	assert.Equal(t, "PSP_SMU_FN_FIRMWARE", entry.TypeInfo.Name)
+
+	directoryEntry.Type = 0x30062
+	entry, _ = ParseEntry(imageBytes, directoryEntry, DefaultFlashMapping)
+	assert.Equal(t, "UEFI PEI Volume", entry.TypeInfo.Comment)
+
+	// Others fall back to the base type
+	directoryEntry.Type = 0x20208
+	entry, _ = ParseEntry(imageBytes, directoryEntry, DefaultFlashMapping)
+	assert.Equal(t, "SMU_OFFCHIP_FW", entry.TypeInfo.Name)
+	assert.Equal(t, uint8(0x08), entry.DirectoryEntry.BaseType())
+	assert.Equal(t, uint8(0x02), entry.DirectoryEntry.SubProgram())
+	assert.Equal(t, uint8(0x02), entry.DirectoryEntry.RomID())
+}
+
+func TestParseEntryValue(t *testing.T) {
+	imageBytes := make([]byte, 0x100)
+	directoryEntry := DirectoryEntry{Type: 0xb, Size: ValueEntrySize, Location: 0x1, Reserved: 0x2}
+
+	entry, err := ParseEntry(imageBytes, directoryEntry, DefaultFlashMapping)
+
+	assert.Nil(t, err)
+	assert.Nil(t, entry.Comment)
+	assert.Nil(t, entry.Raw)
+	assert.Nil(t, entry.Header)
+	assert.Equal(t, "AMD_SOFT_FUSE_CHAIN_01", entry.TypeInfo.Name)
+	assert.True(t, entry.DirectoryEntry.IsValueEntry())
+	assert.Equal(t, uint64(0x200000001), entry.DirectoryEntry.Value())
+}
+
+func TestIsPublicKeyTypeBaseType(t *testing.T) {
+	assert.True(t, IsPublicKeyType(0x00))
+	assert.True(t, IsPublicKeyType(0x1000A))
+	assert.False(t, IsPublicKeyType(0x101))
+}
+
+func TestParseEntryZeroSizeAtImageEnd(t *testing.T) {
+	imageBytes := make([]byte, 0x1000)
+	directoryEntry := DirectoryEntry{Type: 0x1, Size: 0, Location: 0xFF0}
+
+	entry, err := ParseEntry(imageBytes, directoryEntry, 0)
+
+	assert.EqualError(t, err, "Not a parsable Entry: Header exceeds the image at 0x00000FF0")
+	assert.NotNil(t, entry)
+}
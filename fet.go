@@ -4,11 +4,29 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"sort"
 )
 
 const FETSignature = uint32(0x55AA55AA)
 const FETDefaultOffset = uint32(0x20000)
 
+// SPI read modes as configured in the FET
+const (
+	SPIReadNormal33M  = uint8(0)
+	SPIReadDualIO112  = uint8(2)
+	SPIReadQuadIO114  = uint8(3)
+	SPIReadDualIO122  = uint8(4)
+	SPIReadQuadIO144  = uint8(5)
+	SPIReadNormal66M  = uint8(6)
+	SPIReadFastRead   = uint8(7)
+	SPIReadModeUnset  = uint8(0xFF)
+	MicronModeMicron  = uint8(0x55)
+	MicronModeAuto    = uint8(0xAA)
+	MicronModeUnset   = uint8(0xFF)
+	efsFirstGenBit    = uint32(1)
+	efsExtensionStart = 0x20
+)
+
 type FirmwareEntryTable struct {
 	Location uint32
 
@@ -17,9 +35,35 @@ type FirmwareEntryTable struct {
 	GecRomBase    *uint32
 	XHCRomBase    *uint32
 	PSPDirBase    *uint32
-	NewPSPDirBase *uint32
-	BHDDirBase    *uint32
-	NewBHDDirBase *uint32
+	NewPSPDirBase *uint32 // Combo directory on newer families
+	BHDDirBase    *uint32 // Family 15h models 60h-6Fh
+	NewBHDDirBase *uint32 // Family 15h models 70h-7Fh and 17h models 00h-0Fh
+
+	// Second generation EFS, nil on tables without it
+	BIOS2DirBase         *uint32 // Family 17h models 10h-2Fh
+	EFSGeneration        *uint32 // Bit 0 cleared on second generation tables
+	BIOS3DirBase         *uint32 // Family 17h models 30h-3Fh and 19h
+	Reserved2C           *uint32
+	PromontoryFWBase     *uint32
+	LPPromontoryFWBase   *uint32
+	Reserved38           *uint32
+	Reserved3C           *uint32
+	SPIReadModeF15       *uint8 // Family 15h models 60h-6Fh
+	SPIFastSpeedF15      *uint8
+	Reserved42           *uint8
+	SPIReadModeF17       *uint8 // Family 17h models 00h-2Fh
+	SPIFastSpeedF17      *uint8
+	QPRDummyCycleF17     *uint8
+	Reserved46           *uint8
+	SPIReadModeF17Mod30  *uint8 // Family 17h models 30h-3Fh and later
+	SPIFastSpeedF17Mod30 *uint8
+	MicronModeF17Mod30   *uint8
+	Reserved4A           *uint8
+	Reserved4B           *uint8
+	Reserved4C           *uint32
+
+	// Bytes the table was parsed from
+	Raw []byte
 }
 
 type binaryFet struct {
@@ -39,34 +83,176 @@ type binaryShortFet struct {
 	XHCRomBase uint32
 }
 
-// Looks for the FET Signature at the often used offsets.
+// Fields following NewBHDDirBase
+type binaryFetExtension struct {
+	BIOS2DirBase         uint32
+	EFSGeneration        uint32
+	BIOS3DirBase         uint32
+	Reserved2C           uint32
+	PromontoryFWBase     uint32
+	LPPromontoryFWBase   uint32
+	Reserved38           uint32
+	Reserved3C           uint32
+	SPIReadModeF15       uint8
+	SPIFastSpeedF15      uint8
+	Reserved42           uint8
+	SPIReadModeF17       uint8
+	SPIFastSpeedF17      uint8
+	QPRDummyCycleF17     uint8
+	Reserved46           uint8
+	SPIReadModeF17Mod30  uint8
+	SPIFastSpeedF17Mod30 uint8
+	MicronModeF17Mod30   uint8
+	Reserved4A           uint8
+	Reserved4B           uint8
+	Reserved4C           uint32
+}
+
+// Offsets the PSP searches for the FET
+var fetOffsets = []uint32{FETDefaultOffset, 0, 0x820000, 0xC20000, 0xE20000, 0xF20000}
+
+// A possible FET with a score of how plausible it is
+type FETCandidate struct {
+	Offset uint32
+	FET    *FirmwareEntryTable
+	// One point per directory pointer landing on a directory, another one if its checksum is valid
+	Score int
+}
+
+// Looks for the FET Signature at the often used and scanned offsets and returns the best candidate.
 func FindFirmwareEntryTable(firmware []byte) (uint32, error) {
+	candidates := FindFirmwareEntryTables(firmware)
+	if len(candidates) == 0 {
+		return 0, fmt.Errorf("No FirmwareTable found")
+	}
+	return candidates[0].Offset, nil
+}
+
+// Looks for the FET Signature at the often used offsets and at every 0x1000 aligned offset
+// found by scanning, and scores every hit.
+// Candidates are sorted by score, on equal score the usual offsets come first, then the scanned ones.
+func FindFirmwareEntryTables(firmware []byte) []FETCandidate {
+	return findFirmwareEntryTables(firmware, 0)
+}
 
-	for _, addr := range []uint32{FETDefaultOffset, 0, 0x820000, 0xC20000, 0xE20000, 0xF20000} {
-		err := checkValidFirmwareEntryTable(firmware, uint32(addr))
-		if err == nil {
-			return addr, nil
+// Offsets and FET locations of the candidates are flash offsets
+func findFirmwareEntryTables(firmware []byte, baseOffset uint32) []FETCandidate {
+	var candidates []FETCandidate
+	space := NewAddressSpace(0, baseOffset, firmware)
+
+	offsets := append([]uint32{}, fetOffsets...)
+	known := make(map[uint32]bool)
+	for _, addr := range fetOffsets {
+		known[addr] = true
+	}
+	hits, _ := FindFirmwareEntryTableByScan(firmware, ScanOptions{})
+	for _, hit := range hits {
+		if addr := space.FromRelative(hit); !known[addr] {
+			known[addr] = true
+			offsets = append(offsets, addr)
 		}
 	}
-	return 0, fmt.Errorf("No FirmwareTable found")
+
+	for _, addr := range offsets {
+		relative, inImage := space.ToRelative(addr)
+		if !inImage {
+			continue
+		}
+		if err := checkValidFirmwareEntryTable(firmware, relative); err != nil {
+			continue
+		}
+		fet, err := ParseFirmwareEntryTable(firmware, relative)
+		if err != nil {
+			continue
+		}
+		fet.Location = addr
+		candidates = append(candidates, FETCandidate{
+			Offset: addr,
+			FET:    fet,
+			Score:  scoreFirmwareEntryTable(firmware, fet, baseOffset),
+		})
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].Score > candidates[j].Score
+	})
+	return candidates
+}
+
+func scoreFirmwareEntryTable(firmware []byte, fet *FirmwareEntryTable, baseOffset uint32) int {
+	mapping, err := getFlashMapping(firmware, fet, baseOffset)
+	if err != nil {
+		mapping = DefaultFlashMapping
+	}
+
+	space := NewAddressSpace(mapping, baseOffset, firmware)
+	score := 0
+	for _, address := range []*uint32{
+		fet.PSPDirBase,
+		fet.NewPSPDirBase,
+		fet.BHDDirBase,
+		fet.NewBHDDirBase,
+		fet.BIOS2DirBase,
+		fet.BIOS3DirBase,
+	} {
+		if address == nil || *address == 0 || *address == ^uint32(0) {
+			continue
+		}
+		directory, err := ParseDirectoryInSpace(firmware, *address, space)
+		if err != nil {
+			continue
+		}
+		score++
+		if valid, _ := directory.ValidateChecksum(); valid {
+			score++
+		}
+	}
+	return score
 }
 
-// Looks for the FET Signature everywhere (slow)
-func FindFirmwareEntryTableByScan(firmware []byte) (uint32, error) {
+// Alignment used by FindFirmwareEntryTableByScan if none is given
+const FETScanAlignment = uint32(0x1000)
 
-	end := len(firmware)
-	for addr := 0; addr <= end-4; addr++ {
-		err := checkValidFirmwareEntryTable(firmware, uint32(addr))
-		if err == nil {
-			return uint32(addr), nil
+type ScanOptions struct {
+	// Only report hits at multiples of Alignment. 0 selects FETScanAlignment, 1 scans unaligned.
+	Alignment uint32
+}
+
+// Looks for the FET Signature everywhere and returns all hits in ascending order
+func FindFirmwareEntryTableByScan(firmware []byte, options ScanOptions) ([]uint32, error) {
+	alignment := options.Alignment
+	if alignment == 0 {
+		alignment = FETScanAlignment
+	}
+
+	signature := make([]byte, 4)
+	binary.LittleEndian.PutUint32(signature, FETSignature)
+
+	var hits []uint32
+	for start := 0; start <= len(firmware)-len(signature); {
+		index := bytes.Index(firmware[start:], signature)
+		if index < 0 {
+			break
 		}
+		addr := uint32(start + index)
+		if addr%alignment == 0 {
+			hits = append(hits, addr)
+			start = int(addr + alignment)
+		} else {
+			// Continue at the next aligned offset
+			start = int((addr/alignment + 1) * alignment)
+		}
+	}
+
+	if len(hits) == 0 {
+		return nil, fmt.Errorf("No FirmwareTable found")
 	}
-	return 0, fmt.Errorf("No FirmwareTable found")
+	return hits, nil
 }
 
 func checkValidFirmwareEntryTable(firmware []byte, address uint32) error {
 
-	if int(address) > len(firmware)+binary.Size(FirmwareEntryTable{}) {
+	if int(address)+4 > len(firmware) {
 		return fmt.Errorf("Not AMD Table Header: Address out of bounds")
 	}
 	potentialMagic := binary.LittleEndian.Uint32(firmware[address:])
@@ -109,56 +295,197 @@ func ParseFirmwareEntryTable(firmware []byte, address uint32) (*FirmwareEntryTab
 		fet.NewPSPDirBase = nil
 		fet.BHDDirBase = nil
 		fet.NewBHDDirBase = nil
+		fet.Raw = append([]byte{}, firmware[address:address+fet.size()]...)
+		return &fet, nil
 	}
 
+	extensionStart := int(address) + efsExtensionStart
+	if extensionStart+binary.Size(binaryFetExtension{}) <= len(firmware) {
+		extension := binaryFetExtension{}
+		if err := binary.Read(bytes.NewReader(firmware[extensionStart:]), binary.LittleEndian, &extension); err != nil {
+			return nil, fmt.Errorf("Could not read FirmwareEntryTable: %v", err)
+		}
+		fet.setExtension(extension)
+	}
+	fet.Raw = append([]byte{}, firmware[address:address+fet.size()]...)
+
 	return &fet, nil
 }
 
-// Writes FET into existing image
-func (fet *FirmwareEntryTable) Write(baseImage []byte, address uint32) error {
+func (fet *FirmwareEntryTable) setExtension(extension binaryFetExtension) {
+	fet.BIOS2DirBase = &extension.BIOS2DirBase
+	fet.EFSGeneration = &extension.EFSGeneration
+	fet.BIOS3DirBase = &extension.BIOS3DirBase
+	fet.Reserved2C = &extension.Reserved2C
+	fet.PromontoryFWBase = &extension.PromontoryFWBase
+	fet.LPPromontoryFWBase = &extension.LPPromontoryFWBase
+	fet.Reserved38 = &extension.Reserved38
+	fet.Reserved3C = &extension.Reserved3C
+	fet.SPIReadModeF15 = &extension.SPIReadModeF15
+	fet.SPIFastSpeedF15 = &extension.SPIFastSpeedF15
+	fet.Reserved42 = &extension.Reserved42
+	fet.SPIReadModeF17 = &extension.SPIReadModeF17
+	fet.SPIFastSpeedF17 = &extension.SPIFastSpeedF17
+	fet.QPRDummyCycleF17 = &extension.QPRDummyCycleF17
+	fet.Reserved46 = &extension.Reserved46
+	fet.SPIReadModeF17Mod30 = &extension.SPIReadModeF17Mod30
+	fet.SPIFastSpeedF17Mod30 = &extension.SPIFastSpeedF17Mod30
+	fet.MicronModeF17Mod30 = &extension.MicronModeF17Mod30
+	fet.Reserved4A = &extension.Reserved4A
+	fet.Reserved4B = &extension.Reserved4B
+	fet.Reserved4C = &extension.Reserved4C
+}
+
+type fetField struct {
+	offset uint32
+	word   *uint32
+	octet  *uint8
+}
 
-	var tempTable interface{}
+// All fields with their offset in the table
+func (fet *FirmwareEntryTable) fields() []fetField {
+	return []fetField{
+		{offset: 0x04, word: fet.ImcRomBase},
+		{offset: 0x08, word: fet.GecRomBase},
+		{offset: 0x0C, word: fet.XHCRomBase},
+		{offset: 0x10, word: fet.PSPDirBase},
+		{offset: 0x14, word: fet.NewPSPDirBase},
+		{offset: 0x18, word: fet.BHDDirBase},
+		{offset: 0x1C, word: fet.NewBHDDirBase},
+		{offset: 0x20, word: fet.BIOS2DirBase},
+		{offset: 0x24, word: fet.EFSGeneration},
+		{offset: 0x28, word: fet.BIOS3DirBase},
+		{offset: 0x2C, word: fet.Reserved2C},
+		{offset: 0x30, word: fet.PromontoryFWBase},
+		{offset: 0x34, word: fet.LPPromontoryFWBase},
+		{offset: 0x38, word: fet.Reserved38},
+		{offset: 0x3C, word: fet.Reserved3C},
+		{offset: 0x40, octet: fet.SPIReadModeF15},
+		{offset: 0x41, octet: fet.SPIFastSpeedF15},
+		{offset: 0x42, octet: fet.Reserved42},
+		{offset: 0x43, octet: fet.SPIReadModeF17},
+		{offset: 0x44, octet: fet.SPIFastSpeedF17},
+		{offset: 0x45, octet: fet.QPRDummyCycleF17},
+		{offset: 0x46, octet: fet.Reserved46},
+		{offset: 0x47, octet: fet.SPIReadModeF17Mod30},
+		{offset: 0x48, octet: fet.SPIFastSpeedF17Mod30},
+		{offset: 0x49, octet: fet.MicronModeF17Mod30},
+		{offset: 0x4A, octet: fet.Reserved4A},
+		{offset: 0x4B, octet: fet.Reserved4B},
+		{offset: 0x4C, word: fet.Reserved4C},
+	}
+}
+
+func (fet *FirmwareEntryTable) hasExtension() bool {
+	for _, field := range fet.fields() {
+		if field.offset >= efsExtensionStart && (field.word != nil || field.octet != nil) {
+			return true
+		}
+	}
+	return false
+}
+
+// Second generation tables clear the lowest bit of the generation word
+func (fet *FirmwareEntryTable) IsSecondGen() bool {
+	return fet.EFSGeneration != nil && *fet.EFSGeneration&efsFirstGenBit == 0
+}
+
+func SPIReadModeName(mode uint8) string {
+	switch mode {
+	case SPIReadNormal33M:
+		return "Normal read (up to 33MHz)"
+	case SPIReadDualIO112:
+		return "Dual IO (1-1-2)"
+	case SPIReadQuadIO114:
+		return "Quad IO (1-1-4)"
+	case SPIReadDualIO122:
+		return "Dual IO (1-2-2)"
+	case SPIReadQuadIO144:
+		return "Quad IO (1-4-4)"
+	case SPIReadNormal66M:
+		return "Normal read (up to 66MHz)"
+	case SPIReadFastRead:
+		return "Fast Read"
+	case SPIReadModeUnset:
+		return "Unset"
+	default:
+		return "Reserved"
+	}
+}
 
+func SPIFastSpeedName(speed uint8) string {
+	switch speed {
+	case 0:
+		return "66.66MHz"
+	case 1:
+		return "33.33MHz"
+	case 2:
+		return "22.22MHz"
+	case 3:
+		return "16.66MHz"
+	case 4:
+		return "100MHz"
+	case 5:
+		return "800KHz"
+	case 0xFF:
+		return "Unset"
+	default:
+		return "Reserved"
+	}
+}
+
+func MicronModeName(mode uint8) string {
+	switch mode {
+	case MicronModeMicron:
+		return "Micron"
+	case MicronModeAuto:
+		return "Auto detect"
+	case MicronModeUnset:
+		return "Unset"
+	default:
+		return "Reserved"
+	}
+}
+
+// Size of the FET as written by Write
+func (fet *FirmwareEntryTable) size() uint32 {
+	size := uint32(binary.Size(binaryFet{}))
 	if fet.PSPDirBase == nil &&
 		fet.NewPSPDirBase == nil &&
 		fet.NewBHDDirBase == nil &&
 		fet.BHDDirBase == nil {
-		tempTable = binaryShortFet{
-			Signature:  fet.Signature,
-			ImcRomBase: *fet.ImcRomBase,
-			GecRomBase: *fet.GecRomBase,
-			XHCRomBase: *fet.XHCRomBase,
-		}
-	} else {
-		tempTable = binaryFet{
-			Signature:     fet.Signature,
-			ImcRomBase:    *fet.ImcRomBase,
-			GecRomBase:    *fet.GecRomBase,
-			XHCRomBase:    *fet.XHCRomBase,
-			PSPDirBase:    *fet.PSPDirBase,
-			NewPSPDirBase: *fet.NewPSPDirBase,
-			BHDDirBase:    *fet.BHDDirBase,
-			NewBHDDirBase: *fet.NewBHDDirBase,
-		}
+		size = uint32(binary.Size(binaryShortFet{}))
+	}
+	if fet.hasExtension() {
+		size = uint32(efsExtensionStart + binary.Size(binaryFetExtension{}))
 	}
+	if uint32(len(fet.Raw)) > size {
+		size = uint32(len(fet.Raw))
+	}
+	return size
+}
 
-	fetSize := binary.Size(tempTable)
+// Writes FET into existing image.
+// The parsed bytes are written back first, so reserved and unknown bytes stay untouched.
+// Fields set to nil leave the bytes in the image as they are.
+func (fet *FirmwareEntryTable) Write(baseImage []byte, address uint32) error {
+
+	fetSize := int(fet.size())
 
 	if len(baseImage) < int(address)+fetSize {
 		return fmt.Errorf("BaseImage to small to insert FET")
 	}
 
-	buf := new(bytes.Buffer)
+	table := baseImage[address : int(address)+fetSize]
+	copy(table, fet.Raw)
 
-	err := binary.Write(buf, binary.LittleEndian, tempTable)
-	if err != nil {
-		return fmt.Errorf("Writing binary failed: %v", err)
-	}
-
-	bytesCopied := copy(baseImage[address:], buf.Bytes())
-
-	if bytesCopied != fetSize {
-		return fmt.Errorf("Writing binary failed: Not all Bytes copied!")
+	binary.LittleEndian.PutUint32(table, fet.Signature)
+	for _, field := range fet.fields() {
+		if field.word != nil {
+			binary.LittleEndian.PutUint32(table[field.offset:], *field.word)
+		} else if field.octet != nil {
+			table[field.offset] = *field.octet
+		}
 	}
 
 	return nil
@@ -26,6 +26,14 @@ var (
 		0xaa, 0x55, 0xaa, 0x55,
 	}
 
+	fetSecondGenBytes = []byte{
+		0xaa, 0x55, 0xaa, 0x55, 0x00, 0x00, 0x00, 0x00, 0x67, 0x45, 0x23, 0x01, 0x00, 0x10, 0x02, 0xff,
+		0x00, 0x10, 0x10, 0xff, 0x00, 0x00, 0x10, 0x00, 0x00, 0x10, 0x1c, 0xff, 0x00, 0xd0, 0x2b, 0xff,
+		0x00, 0x90, 0x3e, 0x00, 0xfe, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x9a, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
+		0xff, 0xff, 0xff, 0x05, 0x04, 0xff, 0xff, 0x03, 0x01, 0xaa, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
+	}
+
 	testImcRomBase    uint32 = 0
 	testGecRomBase    uint32 = 0x01234567
 	testXHCRomBase    uint32 = 0xFF021000
@@ -105,10 +113,35 @@ func TestFindFirmwareEntryTableFail(t *testing.T) {
 }
 
 func TestFindFirmwareEntryTableByScanFETDefaultOffset(t *testing.T) {
-	offset, err := FindFirmwareEntryTableByScan(mockFetImage())
+	offsets, err := FindFirmwareEntryTableByScan(mockFetImage(), ScanOptions{})
 
 	assert.Nil(t, err)
-	assert.Equal(t, FETDefaultOffset, offset)
+	assert.Equal(t, []uint32{FETDefaultOffset}, offsets)
+}
+
+func TestFindFirmwareEntryTableByScanAllHits(t *testing.T) {
+	imageBytes := mockFetImage()
+	copy(imageBytes[0x820000:], fetBytes)
+	copy(imageBytes[0x820123:], fetBytes)
+	// Signature reaching the end of the image
+	copy(imageBytes[testImage16MB-4:], fetBytes[:4])
+
+	offsets, err := FindFirmwareEntryTableByScan(imageBytes, ScanOptions{})
+
+	assert.Nil(t, err)
+	assert.Equal(t, []uint32{FETDefaultOffset, 0x820000}, offsets)
+
+	offsets, err = FindFirmwareEntryTableByScan(imageBytes, ScanOptions{Alignment: 1})
+
+	assert.Nil(t, err)
+	assert.Equal(t, []uint32{FETDefaultOffset, 0x820000, 0x820123, testImage16MB - 4}, offsets)
+}
+
+func TestFindFirmwareEntryTableByScanNoHits(t *testing.T) {
+	offsets, err := FindFirmwareEntryTableByScan(make([]byte, 500), ScanOptions{Alignment: 1})
+
+	assert.EqualError(t, err, "No FirmwareTable found")
+	assert.Nil(t, offsets)
 }
 
 func TestFindFirmwareEntryTableFETDefaultOffset(t *testing.T) {
@@ -123,6 +156,7 @@ func TestParseFirmwareEntryTable(t *testing.T) {
 
 	expectedFet := testFet
 	expectedFet.Location = 0
+	expectedFet.Raw = fetBytes
 
 	assert.Nil(t, err)
 	assert.Equal(t, expectedFet, *entryTable)
@@ -136,6 +170,7 @@ func TestParseFirmwareEntryTableShort(t *testing.T) {
 
 	expectedFet := testShortFet
 	expectedFet.Location = 0
+	expectedFet.Raw = fetXHCIBytes[:0x10]
 
 	assert.Nil(t, err)
 	assert.Equal(t, expectedFet, *entryTable)
@@ -145,8 +180,85 @@ func TestParseFirmwareEntryTableAtAddress(t *testing.T) {
 	baseImage := mockFetImage()
 	entryTable, err := ParseFirmwareEntryTable(baseImage, FETDefaultOffset)
 
+	// The mock image is zeroed behind the first generation fields
+	expectedFet := testFet
+	expectedFet.setExtension(binaryFetExtension{})
+	expectedFet.Raw = baseImage[FETDefaultOffset : FETDefaultOffset+0x50]
+
+	assert.Nil(t, err)
+	assert.Equal(t, expectedFet, *entryTable)
+}
+
+func TestParseFirmwareEntryTableSecondGen(t *testing.T) {
+	entryTable, err := ParseFirmwareEntryTable(fetSecondGenBytes, 0)
+
+	assert.Nil(t, err)
+	assert.True(t, entryTable.IsSecondGen())
+	assert.Equal(t, uint32(0x3E9000), *entryTable.BIOS2DirBase)
+	assert.Equal(t, uint32(0xFFFFFFFF), *entryTable.BIOS3DirBase)
+	assert.Equal(t, uint32(0), *entryTable.PromontoryFWBase)
+	assert.Equal(t, uint32(0xFF9A0000), *entryTable.LPPromontoryFWBase)
+	assert.Equal(t, SPIReadModeUnset, *entryTable.SPIReadModeF15)
+	assert.Equal(t, SPIReadQuadIO144, *entryTable.SPIReadModeF17)
+	assert.Equal(t, uint8(4), *entryTable.SPIFastSpeedF17)
+	assert.Equal(t, SPIReadQuadIO114, *entryTable.SPIReadModeF17Mod30)
+	assert.Equal(t, uint8(1), *entryTable.SPIFastSpeedF17Mod30)
+	assert.Equal(t, MicronModeAuto, *entryTable.MicronModeF17Mod30)
+	assert.Equal(t, "Quad IO (1-4-4)", SPIReadModeName(*entryTable.SPIReadModeF17))
+	assert.Equal(t, "100MHz", SPIFastSpeedName(*entryTable.SPIFastSpeedF17))
+	assert.Equal(t, "Auto detect", MicronModeName(*entryTable.MicronModeF17Mod30))
+}
+
+func TestFirmwareEntryTable_WriteSecondGen(t *testing.T) {
+	entryTable, err := ParseFirmwareEntryTable(fetSecondGenBytes, 0)
 	assert.Nil(t, err)
-	assert.Equal(t, testFet, *entryTable)
+
+	imageBytes := make([]byte, 500)
+	expectedBytes := make([]byte, 500)
+	copy(expectedBytes[100:], fetSecondGenBytes)
+
+	err = entryTable.Write(imageBytes, 100)
+
+	assert.Nil(t, err)
+	assert.Equal(t, expectedBytes, imageBytes)
+}
+
+func TestFirmwareEntryTable_WriteRoundTrip(t *testing.T) {
+	fetBytes := append([]byte{}, fetSecondGenBytes...)
+	// Reserved fields with unexpected content
+	fetBytes[0x2C] = 0x12
+	fetBytes[0x42] = 0x34
+	fetBytes[0x4F] = 0x56
+
+	entryTable, err := ParseFirmwareEntryTable(fetBytes, 0)
+	assert.Nil(t, err)
+
+	imageBytes := make([]byte, len(fetBytes))
+	err = entryTable.Write(imageBytes, 0)
+
+	assert.Nil(t, err)
+	assert.Equal(t, fetBytes, imageBytes)
+}
+
+func TestFirmwareEntryTable_WriteNilLeavesBytes(t *testing.T) {
+	imageBytes := make([]byte, 500)
+	for i := range imageBytes {
+		imageBytes[i] = 0xEE
+	}
+
+	nilFet := testFet
+	nilFet.ImcRomBase = nil
+	nilFet.XHCRomBase = nil
+
+	err := nilFet.Write(imageBytes, 0)
+
+	expectedBytes := append([]byte{}, fetBytes...)
+	copy(expectedBytes[0x4:], []byte{0xEE, 0xEE, 0xEE, 0xEE})
+	copy(expectedBytes[0xC:], []byte{0xEE, 0xEE, 0xEE, 0xEE})
+
+	assert.Nil(t, err)
+	assert.Equal(t, expectedBytes, imageBytes[:0x20])
+	assert.Equal(t, byte(0xEE), imageBytes[0x20])
 }
 
 func TestParseFirmwareEntryTableFailToSmall(t *testing.T) {
@@ -209,3 +321,52 @@ func TestFirmwareEntryTable_WriteFailToSmall(t *testing.T) {
 
 	assert.EqualError(t, err, "BaseImage to small to insert FET")
 }
+
+func mockStaleFetImage() []byte {
+	imageBytes := make([]byte, testImage16MB)
+
+	// Stale copy at the default offset pointing to erased flash
+	copy(imageBytes[FETDefaultOffset:], fetBytes)
+	copy(imageBytes[FETDefaultOffset+0x10:], []byte{0x00, 0x00, 0x50, 0xff})
+
+	copy(imageBytes[0xE20000:], fetBytes)
+	directory := testPSPMiniDirectory
+	directory.UpdateChecksum()
+	directory.Write(imageBytes, DefaultFlashMapping)
+	return imageBytes
+}
+
+func TestFindFirmwareEntryTables(t *testing.T) {
+	candidates := FindFirmwareEntryTables(mockStaleFetImage())
+
+	assert.Equal(t, 2, len(candidates))
+	assert.Equal(t, uint32(0xE20000), candidates[0].Offset)
+	assert.Equal(t, 2, candidates[0].Score)
+	assert.Equal(t, testPSPDirBase, *candidates[0].FET.PSPDirBase)
+	assert.Equal(t, FETDefaultOffset, candidates[1].Offset)
+	assert.Equal(t, 0, candidates[1].Score)
+}
+
+func TestFindFirmwareEntryTableBestCandidate(t *testing.T) {
+	offset, err := FindFirmwareEntryTable(mockStaleFetImage())
+
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(0xE20000), offset)
+}
+
+func TestFindFirmwareEntryTablesScanned(t *testing.T) {
+	imageBytes := mockStaleFetImage()
+	// Move the valid FET to an offset not in the list of usual offsets
+	copy(imageBytes[0x123000:], imageBytes[0xE20000:0xE20000+len(fetBytes)])
+	for i := range fetBytes {
+		imageBytes[0xE20000+i] = 0
+	}
+
+	candidates := FindFirmwareEntryTables(imageBytes)
+
+	assert.Equal(t, 2, len(candidates))
+	assert.Equal(t, uint32(0x123000), candidates[0].Offset)
+	assert.Equal(t, uint32(0x123000), candidates[0].FET.Location)
+	assert.Equal(t, 2, candidates[0].Score)
+	assert.Equal(t, FETDefaultOffset, candidates[1].Offset)
+}
@@ -7,4 +7,5 @@ require (
 	github.com/go-openapi/strfmt v0.19.0 // indirect
 	github.com/jedib0t/go-pretty v4.2.1+incompatible
 	github.com/mattn/go-runewidth v0.0.4 // indirect
+	github.com/stretchr/testify v1.2.2
 )
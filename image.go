@@ -1,6 +1,7 @@
 package amdfw
 
 import (
+	"errors"
 	"fmt"
 )
 
@@ -9,32 +10,90 @@ type (
 		FET          *FirmwareEntryTable
 		FlashMapping *uint32
 		Roms         []*Rom
+		// Other FETs found in the image, best first
+		AlternativeFETs []FETCandidate
+		// Flash offset of the first byte the image was parsed from
+		BaseOffset uint32
+		// Number of directory levels followed when parsing and resolving.
+		// 0 selects DefaultMaxDirectoryDepth.
+		MaxDirectoryDepth int
 	}
+
+	ParseOptions struct {
+		// Flash offset of the first byte passed in.
+		// Set when parsing a region extracted from a larger flash, e.g. the last 4MB.
+		BaseOffset uint32
+		// Number of directory levels followed below a directory referenced by the FET.
+		// 0 selects DefaultMaxDirectoryDepth.
+		MaxDirectoryDepth int
+	}
+
+	WriteOptions struct {
+		// Write the directory and Image Slot Header checksums as they are instead of recalculating them
+		KeepChecksums bool
+	}
+
+	// Errors of all roms of an image. errors.Is and errors.As match each of them.
+	RomErrors []error
 )
 
+func (errs RomErrors) Error() string {
+	return fmt.Sprintf("Errors parsing images %v", []error(errs))
+}
+
+func (errs RomErrors) Is(target error) bool {
+	for _, err := range errs {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+func (errs RomErrors) As(target interface{}) bool {
+	for _, err := range errs {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
 func ParseImage(firmwareBytes []byte) (*Image, error) {
-	image := Image{}
+	return ParseImageWithOptions(firmwareBytes, ParseOptions{})
+}
 
-	fetOffset, err := FindFirmwareEntryTable(firmwareBytes)
-	if err != nil {
-		return nil, fmt.Errorf("Could not parse Image: %v", err)
+// Parses a full flash image or a part of it.
+// Entries located outside of the passed bytes are marked as External.
+func ParseImageWithOptions(firmwareBytes []byte, options ParseOptions) (*Image, error) {
+	image := Image{
+		BaseOffset:        options.BaseOffset,
+		MaxDirectoryDepth: options.maxDirectoryDepth(),
 	}
 
-	fet, err := ParseFirmwareEntryTable(firmwareBytes, fetOffset)
-	if err != nil {
-		return nil, fmt.Errorf("Could not parse Image: %v", err)
+	candidates := findFirmwareEntryTables(firmwareBytes, options.BaseOffset)
+	if len(candidates) == 0 {
+		// Some AM1 CPUs and partially erased dumps come without FET
+		fallback, scanErr := parseImageWithoutFET(firmwareBytes, options)
+		if fallback == nil {
+			return nil, fmt.Errorf("Could not parse Image: No FirmwareTable found: %w", scanErr)
+		}
+		return fallback, scanErr
 	}
+
+	fet := candidates[0].FET
 	image.FET = fet
+	image.AlternativeFETs = candidates[1:]
 
-	mapping, err := GetFlashMapping(firmwareBytes, fet)
+	mapping, err := getFlashMapping(firmwareBytes, fet, options.BaseOffset)
 	if err != nil {
 		return nil, fmt.Errorf("Could not parse Image: %v", err)
 	}
 	image.FlashMapping = &mapping
 
-	roms, errs := ParseRoms(firmwareBytes, fet, mapping)
+	roms, errs := parseRoms(firmwareBytes, fet, image.AddressSpace(firmwareBytes), image.MaxDirectoryDepth)
 	if len(errs) != 0 {
-		err = fmt.Errorf("Errors parsing images %v", errs)
+		err = RomErrors(errs)
 	} else {
 		err = nil
 	}
@@ -43,15 +102,45 @@ func ParseImage(firmwareBytes []byte) (*Image, error) {
 	return &image, err
 }
 
+func (options ParseOptions) maxDirectoryDepth() int {
+	if options.MaxDirectoryDepth <= 0 {
+		return DefaultMaxDirectoryDepth
+	}
+	return options.MaxDirectoryDepth
+}
+
+// Writes the image with default options. All directory and Image Slot Header checksums are recalculated.
 func (image *Image) Write(baseImage []byte) ([]byte, error) {
+	return image.WriteWithOptions(baseImage, WriteOptions{})
+}
+
+func (image *Image) WriteWithOptions(baseImage []byte, options WriteOptions) ([]byte, error) {
 	var err error
-	if err = image.FET.Write(baseImage, image.FET.Location); err != nil {
-		return nil, err
+	space := image.AddressSpace(baseImage)
+
+	if image.FET != nil {
+		location, inImage := space.ToRelative(image.FET.Location)
+		if !inImage {
+			return nil, fmt.Errorf("BaseImage to small to insert FET")
+		}
+		if err = image.FET.Write(baseImage, location); err != nil {
+			return nil, err
+		}
 	}
 
 	if image.FlashMapping != nil {
 		for _, rom := range image.Roms {
-			if err = rom.Write(baseImage, image.FET, *image.FlashMapping); err != nil {
+			if !options.KeepChecksums {
+				for _, directory := range rom.Directories {
+					directory.UpdateChecksum()
+				}
+				for _, slot := range rom.Slots {
+					if slot.Header != nil {
+						slot.Header.UpdateChecksum()
+					}
+				}
+			}
+			if err = rom.WriteInSpace(baseImage, image.FET, space); err != nil {
 				return nil, err
 			}
 
@@ -1,6 +1,7 @@
 package amdfw
 
 import (
+	"errors"
 	"fmt"
 	"github.com/stretchr/testify/assert"
 	"testing"
@@ -34,7 +35,7 @@ func mockImage() []byte {
 func TestImage_Write(t *testing.T) {
 	baseImage := make([]byte, testImage16MB)
 
-	baseImage, err := testImage.Write(baseImage)
+	baseImage, err := testImage.WriteWithOptions(baseImage, WriteOptions{KeepChecksums: true})
 
 	assert.Nil(t, err)
 
@@ -45,3 +46,123 @@ func TestImage_Write(t *testing.T) {
 	}
 
 }
+
+func TestImage_WriteUpdatesChecksums(t *testing.T) {
+	directory := testPSPMiniDirectory
+	image := testImage
+	image.Roms = []*Rom{{Type: PSPRom, Directories: []*Directory{&directory}}}
+
+	baseImage, err := image.Write(make([]byte, testImage16MB))
+	assert.Nil(t, err)
+
+	parsed, err := ParseDirectory(baseImage, testPSPDirBase, DefaultFlashMapping)
+	assert.Nil(t, err)
+
+	valid, actual := parsed.ValidateChecksum()
+	assert.True(t, valid)
+	assert.Equal(t, actual, directory.Header.Checksum)
+	assert.NotEqual(t, testPSPMiniDirectory.Header.Checksum, actual)
+}
+
+func TestParseImageAlternativeFETs(t *testing.T) {
+	// Only the PSP directory is present, errors about the other ROMs are expected
+	image, _ := ParseImage(mockStaleFetImage())
+
+	assert.NotNil(t, image)
+	assert.Equal(t, uint32(0xE20000), image.FET.Location)
+	assert.Equal(t, 1, len(image.AlternativeFETs))
+	assert.Equal(t, FETDefaultOffset, image.AlternativeFETs[0].Offset)
+}
+
+// Image with a $PSP directory referencing a blob in front of 0x100000
+func mockPartialImage(t *testing.T) []byte {
+	image, imageBytes := mockAllocatorImage()
+	pspDirectory := image.Roms[0].Directories[0]
+	assert.Nil(t, pspDirectory.AddEntry(Entry{DirectoryEntry: DirectoryEntry{Type: 0x3, Size: 0x100, Location: 0xFF010000}}))
+
+	imageBytes, err := image.Write(imageBytes)
+	assert.Nil(t, err)
+	return imageBytes
+}
+
+func TestParseImageWithOptionsBaseOffset(t *testing.T) {
+	imageBytes := mockPartialImage(t)
+
+	image, err := ParseImageWithOptions(imageBytes[0x100000:0x200000], ParseOptions{BaseOffset: 0x100000})
+
+	assert.Nil(t, err)
+	assert.Nil(t, image.FET)
+	assert.Equal(t, uint32(0x100000), image.BaseOffset)
+	assert.Equal(t, DefaultFlashMapping, *image.FlashMapping)
+	// The $PL2 directory is reached through the $PSP directory
+	assert.Equal(t, 1, len(image.Roms))
+	assert.Equal(t, 2, len(image.Roms[0].Directories))
+
+	pspDirectory := image.Roms[0].Directories[0]
+	assert.Equal(t, uint32(0x101000), pspDirectory.Location)
+	assert.Equal(t, imageBytes[0x102000:0x103000], pspDirectory.Entries[0].Raw)
+	assert.False(t, pspDirectory.Entries[0].External)
+
+	external := pspDirectory.Entries[4]
+	assert.Equal(t, uint32(0x3), external.DirectoryEntry.Type)
+	assert.True(t, external.External)
+	assert.Nil(t, external.Raw)
+	assert.Equal(t, []string{"External: 0x00010000 is not part of the image"}, external.Comment)
+}
+
+func TestParseImageWithOptionsBaseOffsetFET(t *testing.T) {
+	imageBytes := mockPartialImage(t)
+
+	image, _ := ParseImageWithOptions(imageBytes[FETDefaultOffset:], ParseOptions{BaseOffset: FETDefaultOffset})
+
+	assert.NotNil(t, image)
+	assert.Equal(t, FETDefaultOffset, image.FET.Location)
+	assert.Equal(t, DefaultFlashMapping, *image.FlashMapping)
+	assert.Equal(t, PSPRom, image.Roms[0].Type)
+	assert.Equal(t, uint32(0x101000), image.Roms[0].Directories[0].Location)
+	assert.Equal(t, imageBytes[0x103000:0x104000], image.Roms[0].Directories[0].Entries[1].Raw)
+	assert.True(t, image.Roms[0].Directories[0].Entries[4].External)
+}
+
+func TestImage_WritePartial(t *testing.T) {
+	imageBytes := mockPartialImage(t)
+	partialBytes := append([]byte{}, imageBytes[0x100000:0x200000]...)
+
+	image, err := ParseImageWithOptions(partialBytes, ParseOptions{BaseOffset: 0x100000})
+	assert.Nil(t, err)
+
+	baseImage := make([]byte, len(partialBytes))
+	for i := range baseImage {
+		baseImage[i] = 0xFF
+	}
+	written, err := image.Write(baseImage)
+
+	assert.Nil(t, err)
+	assert.Equal(t, partialBytes, written)
+}
+
+func TestParseImageLoopError(t *testing.T) {
+	image, imageBytes := mockAllocatorImage()
+	secondDirectory := image.Roms[0].Directories[1]
+	assert.Nil(t, secondDirectory.AddEntry(Entry{DirectoryEntry: DirectoryEntry{Type: 0x40, Size: 0x50, Location: 0xFF101000}}))
+	imageBytes, err := image.Write(imageBytes)
+	assert.Nil(t, err)
+
+	_, err = ParseImage(imageBytes)
+
+	var loop *DirectoryLoopError
+	assert.True(t, errors.As(err, &loop))
+	assert.Equal(t, []uint32{0x101000, 0x104000}, loop.Path)
+	assert.Contains(t, err.Error(), "Errors parsing images [")
+}
+
+func TestRomErrors(t *testing.T) {
+	depth := &DirectoryDepthError{Location: 0x1000, MaxDepth: 1}
+	errs := RomErrors{fmt.Errorf("Could not parse bhd rom: No BHD offset available"), fmt.Errorf("Could not parse psp rom: %w", depth)}
+
+	var target *DirectoryDepthError
+	assert.True(t, errors.As(errs, &target))
+	assert.Equal(t, depth, target)
+	assert.True(t, errors.Is(errs, depth))
+	assert.False(t, errors.As(RomErrors{errs[0]}, &target))
+}
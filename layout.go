@@ -0,0 +1,214 @@
+package amdfw
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	FETRegion       RegionType = "FET"
+	DirectoryRegion RegionType = "Directory"
+	EntryRegion     RegionType = "Entry"
+	RomRegion       RegionType = "Rom"
+	ErasedRegion    RegionType = "Erased"
+	UnknownRegion   RegionType = "Unknown"
+)
+
+// Granularity used to find the end of ROMs without length information
+const layoutBlockSize = uint32(0x1000)
+
+// Shorter runs of 0xFF are considered part of the surrounding data
+const minErasedRun = uint32(0x10)
+
+type (
+	RegionType string
+
+	// A range of flash offsets and everything referencing it
+	Region struct {
+		Start   uint32
+		Size    uint32
+		Type    RegionType
+		Owners  []string
+		Entries []*Entry
+	}
+)
+
+func (region Region) End() uint32 {
+	return region.Start + region.Size
+}
+
+// Returns every region of the image sorted by flash offset. Bytes not claimed by the FET,
+// a directory or an entry are reported as erased or unknown gaps.
+// Regions referenced by multiple directories are reported once with all owners.
+func (image *Image) Layout(firmwareBytes []byte) []Region {
+	imageSize := uint32(len(firmwareBytes))
+	space := image.AddressSpace(firmwareBytes)
+
+	// Regions are collected relative to the image and converted to flash offsets at the end
+	var regions []Region
+	add := func(address uint32, size uint32, regionType RegionType, owner string, entry *Entry) {
+		start, inImage := space.ToRelative(address)
+		if size == 0 || !inImage {
+			return
+		}
+		if uint64(start)+uint64(size) > uint64(imageSize) {
+			size = imageSize - start
+		}
+		for i := range regions {
+			if regions[i].Start == start && regions[i].Size == size && regions[i].Type == regionType {
+				regions[i].Owners = append(regions[i].Owners, owner)
+				if entry != nil {
+					regions[i].Entries = append(regions[i].Entries, entry)
+				}
+				return
+			}
+		}
+		region := Region{Start: start, Size: size, Type: regionType, Owners: []string{owner}}
+		if entry != nil {
+			region.Entries = []*Entry{entry}
+		}
+		regions = append(regions, region)
+	}
+
+	if image.FET != nil {
+		add(image.FET.Location, image.FET.size(), FETRegion, "Firmware Entry Table", nil)
+	}
+
+	for _, rom := range image.Roms {
+		for _, directory := range rom.Directories {
+			cookie := string(directory.Header.Cookie[:])
+			add(directory.Location, directory.Size(), DirectoryRegion, fmt.Sprintf("%s %s", rom.Type, cookie), nil)
+
+			if directory.IsCombo() {
+				continue
+			}
+
+			for i := range directory.Entries {
+				entry := &directory.Entries[i]
+				// Level 2 pointers are covered by the region of the directory they point to
+				if entry.DirectoryEntry.IsValueEntry() || isDirectoryType(entry.DirectoryEntry.Type) {
+					continue
+				}
+				name := ""
+				if entry.TypeInfo != nil {
+					name = entry.TypeInfo.Name
+				}
+				owner := strings.TrimSpace(fmt.Sprintf("%s %s[%d] 0x%X %s", rom.Type, cookie, i, entry.DirectoryEntry.Type, name))
+				add(directory.ResolveLocation(&entry.DirectoryEntry, space), entry.DirectoryEntry.Size, EntryRegion, owner, entry)
+			}
+		}
+	}
+
+	// ROMs without length information extend up to the next region or erased block
+	if image.FET != nil {
+		for _, rom := range []struct {
+			base    *uint32
+			romType RomType
+		}{
+			{image.FET.ImcRomBase, IMCRom},
+			{image.FET.GecRomBase, GECRom},
+			{image.FET.XHCRomBase, XHCIRom},
+		} {
+			if rom.base == nil || *rom.base == 0 || *rom.base == ^uint32(0) {
+				continue
+			}
+			start, inImage := space.ToRelative(*rom.base)
+			if !inImage {
+				continue
+			}
+			end := imageSize
+			for _, region := range regions {
+				if region.Start > start && region.Start < end {
+					end = region.Start
+				}
+			}
+			if erased := findErasedBlock(firmwareBytes, start, end); erased < end {
+				end = erased
+			}
+			add(space.FromRelative(start), end-start, RomRegion, string(rom.romType), nil)
+		}
+	}
+
+	sort.SliceStable(regions, func(i, j int) bool {
+		if regions[i].Start == regions[j].Start {
+			return regions[i].Size > regions[j].Size
+		}
+		return regions[i].Start < regions[j].Start
+	})
+
+	// Fill the gaps
+	var layout []Region
+	position := uint32(0)
+	for _, region := range regions {
+		if region.Start > position {
+			layout = append(layout, gapRegions(firmwareBytes, position, region.Start)...)
+		}
+		layout = append(layout, region)
+		if region.End() > position {
+			position = region.End()
+		}
+	}
+	if position < imageSize {
+		layout = append(layout, gapRegions(firmwareBytes, position, imageSize)...)
+	}
+
+	for i := range layout {
+		layout[i].Start = space.FromRelative(layout[i].Start)
+	}
+	return layout
+}
+
+// Sums up all erased gaps
+func FreeSpace(layout []Region) uint32 {
+	free := uint32(0)
+	for _, region := range layout {
+		if region.Type == ErasedRegion {
+			free += region.Size
+		}
+	}
+	return free
+}
+
+// Splits an unclaimed range into erased and unknown parts.
+// Short runs of 0xFF inside data are not considered erased.
+func gapRegions(firmwareBytes []byte, start uint32, end uint32) []Region {
+	var gaps []Region
+	dataStart := start
+	position := start
+	for position < end {
+		if firmwareBytes[position] != 0xFF {
+			position++
+			continue
+		}
+
+		runEnd := position
+		for runEnd < end && firmwareBytes[runEnd] == 0xFF {
+			runEnd++
+		}
+
+		if runEnd-position >= minErasedRun || (position == start && runEnd == end) {
+			if position > dataStart {
+				gaps = append(gaps, Region{Start: dataStart, Size: position - dataStart, Type: UnknownRegion})
+			}
+			gaps = append(gaps, Region{Start: position, Size: runEnd - position, Type: ErasedRegion})
+			dataStart = runEnd
+		}
+		position = runEnd
+	}
+	if end > dataStart {
+		gaps = append(gaps, Region{Start: dataStart, Size: end - dataStart, Type: UnknownRegion})
+	}
+	return gaps
+}
+
+// Returns the start of the first aligned, fully erased block in range or end
+func findErasedBlock(firmwareBytes []byte, start uint32, end uint32) uint32 {
+	block := (start + layoutBlockSize - 1) &^ (layoutBlockSize - 1)
+	for ; block+layoutBlockSize <= end; block += layoutBlockSize {
+		if allOneValue(firmwareBytes[block:block+layoutBlockSize]) && firmwareBytes[block] == 0xFF {
+			return block
+		}
+	}
+	return end
+}
@@ -0,0 +1,60 @@
+package amdfw
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestImage_Layout(t *testing.T) {
+	image, imageBytes := mockAllocatorImage()
+	copy(imageBytes[0x21000:], []byte{0x55, 0xAA, 0x01})
+
+	layout := image.Layout(imageBytes)
+
+	expected := []struct {
+		start      uint32
+		size       uint32
+		regionType RegionType
+	}{
+		{0x0, 0x20000, UnknownRegion},
+		{0x20000, 0x20, FETRegion},
+		{0x20020, 0xFE0, ErasedRegion},
+		{0x21000, 0x1000, RomRegion},
+		{0x22000, 0xDF000, ErasedRegion},
+		{0x101000, 0x50, DirectoryRegion},
+		{0x101050, 0xFB0, ErasedRegion},
+		{0x102000, 0x1000, EntryRegion},
+		{0x103000, 0x1000, EntryRegion},
+		{0x104000, 0x20, DirectoryRegion},
+		{0x104020, testImage16MB - 0x104020, ErasedRegion},
+	}
+
+	assert.Equal(t, len(expected), len(layout))
+	for i, region := range layout {
+		assert.Equal(t, expected[i].start, region.Start, "Region %d", i)
+		assert.Equal(t, expected[i].size, region.Size, "Region %d", i)
+		assert.Equal(t, expected[i].regionType, region.Type, "Region %d", i)
+	}
+
+	// Both directories reference the first blob
+	assert.Equal(t, []string{"PSP $PSP[0] 0x1", "PSP $PL2[0] 0x1"}, layout[7].Owners)
+	assert.Equal(t, 2, len(layout[7].Entries))
+	assert.Equal(t, []string{"XHCI"}, layout[3].Owners)
+
+	assert.Equal(t, uint32(0xFE0+0xDF000+0xFB0+testImage16MB-0x104020), FreeSpace(layout))
+}
+
+func TestGapRegionsShortRuns(t *testing.T) {
+	data := []byte{0x01, 0xFF, 0xFF, 0x02}
+	data = append(data, make([]byte, 0x10)...)
+	for i := 4; i < len(data); i++ {
+		data[i] = 0xFF
+	}
+
+	gaps := gapRegions(data, 0, uint32(len(data)))
+
+	assert.Equal(t, []Region{
+		{Start: 0, Size: 4, Type: UnknownRegion},
+		{Start: 4, Size: 0x10, Type: ErasedRegion},
+	}, gaps)
+}
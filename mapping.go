@@ -8,6 +8,10 @@ import (
 const DefaultFlashMapping = uint32(0xFF000000)
 
 func GetFlashMapping(firmwareBytes []byte, fet *FirmwareEntryTable) (uint32, error) {
+	return getFlashMapping(firmwareBytes, fet, 0)
+}
+
+func getFlashMapping(firmwareBytes []byte, fet *FirmwareEntryTable, baseOffset uint32) (uint32, error) {
 
 	type mappingMagic struct {
 		addr  *uint32
@@ -22,16 +26,16 @@ func GetFlashMapping(firmwareBytes []byte, fet *FirmwareEntryTable) (uint32, err
 		magic: []string{PSPCOOCKIE, DUALPSPCOOCKIE},
 	}, {
 		addr:  fet.BHDDirBase,
-		magic: []string{BHDCOOCKIE},
+		magic: []string{BHDCOOCKIE, DUALBHDCOOCKIE},
 	}, {
 		addr:  fet.BHDDirBase,
-		magic: []string{BHDCOOCKIE},
+		magic: []string{BHDCOOCKIE, DUALBHDCOOCKIE},
 	},
 	} {
 		for _, m := range s.magic {
 
 			if s.addr != nil && *s.addr != 0 {
-				mapping, err := testMapping(firmwareBytes, *s.addr, m)
+				mapping, err := testMapping(firmwareBytes, *s.addr, m, baseOffset)
 				if err == nil {
 					return mapping, nil
 				}
@@ -41,24 +45,18 @@ func GetFlashMapping(firmwareBytes []byte, fet *FirmwareEntryTable) (uint32, err
 	return 0, fmt.Errorf("No valid mapping found!")
 }
 
-func testMapping(firmwareBytes []byte, address uint32, expected string) (uint32, error) {
+func testMapping(firmwareBytes []byte, address uint32, expected string, baseOffset uint32) (uint32, error) {
 
-	for _, mapping := range []uint32{
-		DefaultFlashMapping + 0x000000, //16M
-		DefaultFlashMapping + 0x800000, // 8M
-		DefaultFlashMapping + 0xB00000, // 4M
-		DefaultFlashMapping + 0xD00000, // 2M
-		DefaultFlashMapping + 0xE00000, // 1M
-		DefaultFlashMapping + 0xE80000, // 512K
-	} {
+	for _, mapping := range flashMappings {
 
 		expectedBytes := []byte(expected)
-		testAddr := address - mapping
-		if int(testAddr) > len(firmwareBytes) {
+		space := NewAddressSpace(mapping, baseOffset, firmwareBytes)
+		if !space.IsMMIO(address) {
 			continue
 		}
 
-		if bytes.Equal(firmwareBytes[testAddr:testAddr+4], expectedBytes) {
+		cookie, err := space.Slice(firmwareBytes, address, uint32(len(expectedBytes)))
+		if err == nil && bytes.Equal(cookie, expectedBytes) {
 			return mapping, nil
 		}
 	}
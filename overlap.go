@@ -0,0 +1,139 @@
+package amdfw
+
+import (
+	"fmt"
+)
+
+const (
+	// Both entries reference exactly the same bytes
+	IdenticalAlias OverlapKind = "identical alias"
+	// The entries share some but not all bytes
+	PartialOverlap OverlapKind = "partial overlap"
+	Disjoint       OverlapKind = "disjoint"
+)
+
+type (
+	OverlapKind string
+
+	// An entry of a directory and the flash range it covers
+	EntryReference struct {
+		Rom       *Rom
+		Directory *Directory
+		Index     int
+		Start     uint32
+		Size      uint32
+	}
+
+	EntryOverlap struct {
+		Kind OverlapKind
+		A    EntryReference
+		B    EntryReference
+	}
+
+	// An entry reaching into a directory table or the FET
+	TableOverlap struct {
+		Entry EntryReference
+		Table Region
+	}
+
+	OverlapReport struct {
+		// All pairs of entries which are not disjoint
+		Entries []EntryOverlap
+		Tables  []TableOverlap
+	}
+)
+
+func (reference EntryReference) End() uint32 {
+	return reference.Start + reference.Size
+}
+
+func (reference EntryReference) String() string {
+	return fmt.Sprintf("%s %s[%d] @ 0x%08X", reference.Rom.Type, string(reference.Directory.Header.Cookie[:]), reference.Index, reference.Start)
+}
+
+// Classifies how two flash ranges relate to each other
+func ClassifyOverlap(startA uint32, sizeA uint32, startB uint32, sizeB uint32) OverlapKind {
+	if startA == startB && sizeA == sizeB {
+		return IdenticalAlias
+	}
+	if uint64(startA) < uint64(startB)+uint64(sizeB) && uint64(startB) < uint64(startA)+uint64(sizeA) {
+		return PartialOverlap
+	}
+	return Disjoint
+}
+
+// Compares every entry of every directory with each other and with all directory tables and the FET.
+// Directories reachable through multiple ROMs are only considered once.
+func (image *Image) AnalyzeOverlaps() OverlapReport {
+	space := image.AddressSpace(nil)
+
+	var tables []Region
+	if image.FET != nil {
+		tables = append(tables, Region{Start: image.FET.Location, Size: image.FET.size(), Type: FETRegion, Owners: []string{"Firmware Entry Table"}})
+	}
+
+	var references []EntryReference
+	seen := make(map[uint32]bool)
+	for _, rom := range image.Roms {
+		for _, directory := range rom.Directories {
+			if seen[directory.Location] {
+				continue
+			}
+			seen[directory.Location] = true
+
+			cookie := string(directory.Header.Cookie[:])
+			tables = append(tables, Region{Start: directory.Location, Size: directory.Size(), Type: DirectoryRegion, Owners: []string{fmt.Sprintf("%s %s", rom.Type, cookie)}})
+
+			// Combo directories only point to other directories
+			if directory.IsCombo() {
+				continue
+			}
+
+			for i, entry := range directory.Entries {
+				if entry.DirectoryEntry.IsValueEntry() || entry.DirectoryEntry.Size == 0 {
+					continue
+				}
+				// Directory and slot pointers reference tables, not data
+				if isDirectoryType(entry.DirectoryEntry.Type) || isSlotType(entry.DirectoryEntry.Type) {
+					continue
+				}
+				references = append(references, EntryReference{
+					Rom:       rom,
+					Directory: directory,
+					Index:     i,
+					Start:     directory.ResolveLocation(&entry.DirectoryEntry, space),
+					Size:      entry.DirectoryEntry.Size,
+				})
+			}
+		}
+	}
+
+	report := OverlapReport{}
+	for i, a := range references {
+		for _, b := range references[i+1:] {
+			if kind := ClassifyOverlap(a.Start, a.Size, b.Start, b.Size); kind != Disjoint {
+				report.Entries = append(report.Entries, EntryOverlap{Kind: kind, A: a, B: b})
+			}
+		}
+		for _, table := range tables {
+			if ClassifyOverlap(a.Start, a.Size, table.Start, table.Size) != Disjoint {
+				report.Tables = append(report.Tables, TableOverlap{Entry: a, Table: table})
+			}
+		}
+	}
+	return report
+}
+
+// Aliases are expected in combo and A/B images. Partial overlaps and entries covering tables are not.
+func (report OverlapReport) Conflicts() []error {
+	var errors []error
+	for _, overlap := range report.Entries {
+		if overlap.Kind == PartialOverlap {
+			errors = append(errors, fmt.Errorf("Entry %s partially overlaps entry %s", overlap.A, overlap.B))
+		}
+	}
+	for _, overlap := range report.Tables {
+		errors = append(errors, fmt.Errorf("Entry %s overlaps %s at 0x%08X", overlap.Entry, overlap.Table.Owners[0], overlap.Table.Start))
+	}
+	return errors
+}
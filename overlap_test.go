@@ -0,0 +1,71 @@
+package amdfw
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestClassifyOverlap(t *testing.T) {
+	assert.Equal(t, IdenticalAlias, ClassifyOverlap(0x1000, 0x100, 0x1000, 0x100))
+	assert.Equal(t, PartialOverlap, ClassifyOverlap(0x1000, 0x100, 0x10FF, 0x100))
+	assert.Equal(t, PartialOverlap, ClassifyOverlap(0x1000, 0x100, 0x1000, 0x80))
+	assert.Equal(t, Disjoint, ClassifyOverlap(0x1000, 0x100, 0x1100, 0x100))
+	assert.Equal(t, Disjoint, ClassifyOverlap(0xFFFFFF00, 0x100, 0x0, 0x100))
+}
+
+func TestImage_AnalyzeOverlaps(t *testing.T) {
+	image, _ := mockAllocatorImage()
+
+	report := image.AnalyzeOverlaps()
+
+	assert.Equal(t, 1, len(report.Entries))
+	assert.Equal(t, IdenticalAlias, report.Entries[0].Kind)
+	assert.Equal(t, 0, report.Entries[0].A.Index)
+	assert.Equal(t, image.Roms[0].Directories[1], report.Entries[0].B.Directory)
+	assert.Equal(t, 0, len(report.Tables))
+	assert.Equal(t, 0, len(report.Conflicts()))
+}
+
+func TestImage_AnalyzeOverlapsConflicts(t *testing.T) {
+	image, _ := mockAllocatorImage()
+	// Reaches into the second entry and the $PL2 table
+	image.Roms[0].Directories[0].Entries[0].DirectoryEntry.Size = 0x2010
+	image.Roms[0].Directories[1].Entries[0].DirectoryEntry.Location = 0xFF101010
+
+	report := image.AnalyzeOverlaps()
+
+	assert.Equal(t, 2, len(report.Entries))
+	assert.Equal(t, PartialOverlap, report.Entries[0].Kind)
+	assert.Equal(t, PartialOverlap, report.Entries[1].Kind)
+	assert.Equal(t, 2, len(report.Tables))
+	assert.Equal(t, DirectoryRegion, report.Tables[0].Table.Type)
+	assert.Equal(t, uint32(0x104000), report.Tables[0].Table.Start)
+	assert.Equal(t, uint32(0x101000), report.Tables[1].Table.Start)
+	assert.Equal(t, []string{
+		"Entry PSP $PSP[0] @ 0x00102000 partially overlaps entry PSP $PSP[1] @ 0x00103000",
+		"Entry PSP $PSP[0] @ 0x00102000 partially overlaps entry PSP $PL2[0] @ 0x00101010",
+		"Entry PSP $PSP[0] @ 0x00102000 overlaps PSP $PL2 at 0x00104000",
+		"Entry PSP $PL2[0] @ 0x00101010 overlaps PSP $PSP at 0x00101000",
+	}, errorStrings(report.Conflicts()))
+}
+
+func errorStrings(errors []error) []string {
+	var messages []string
+	for _, err := range errors {
+		messages = append(messages, err.Error())
+	}
+	return messages
+}
+
+func TestImage_AnalyzeOverlapsPointers(t *testing.T) {
+	image, _ := mockAllocatorImage()
+	// Slot pointer to a header in front of the $PL2 table
+	pspDirectory := image.Roms[0].Directories[0]
+	pspDirectory.Entries = append(pspDirectory.Entries, Entry{DirectoryEntry: DirectoryEntry{Type: 0x48, Size: 0x4000, Location: 0xFF101000}})
+
+	report := image.AnalyzeOverlaps()
+
+	assert.Equal(t, 1, len(report.Entries))
+	assert.Equal(t, 0, len(report.Tables))
+	assert.Equal(t, 0, len(report.Conflicts()))
+}
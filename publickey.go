@@ -0,0 +1,127 @@
+package amdfw
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+)
+
+const PublicKeyHeaderSize = 0x40
+
+// Entry types carrying a public key token instead of a headered blob
+var publicKeyTypes = []uint32{0x00, 0x05, 0x09, 0x0A, 0x0D}
+
+type (
+	PublicKey struct {
+		Version      uint32
+		KeyID        [0x10]byte
+		CertifyingID [0x10]byte
+		KeyUsage     uint32
+		Reserved     [0x10]byte
+		ExponentSize uint32 // in bits
+		ModulusSize  uint32 // in bits
+		Exponent     *big.Int
+		Modulus      *big.Int
+		Signature    []byte
+	}
+
+	binaryPublicKeyHeader struct {
+		Version      uint32
+		KeyID        [0x10]byte
+		CertifyingID [0x10]byte
+		KeyUsage     uint32
+		Reserved     [0x10]byte
+		ExponentSize uint32
+		ModulusSize  uint32
+	}
+)
+
+// Only the base type is compared, subprogram and rom ID bits are ignored
+func IsPublicKeyType(entryType uint32) bool {
+	for _, keyType := range publicKeyTypes {
+		if entryType&0xFF == keyType {
+			return true
+		}
+	}
+	return false
+}
+
+// Parses an AMD key token. Exponent and modulus are stored little endian.
+// Everything following the modulus is treated as the signature of the certifying key.
+func ParsePublicKey(raw []byte) (*PublicKey, error) {
+	header := binaryPublicKeyHeader{}
+	if err := binary.Read(bytes.NewReader(raw), binary.LittleEndian, &header); err != nil {
+		return nil, fmt.Errorf("Could not read key header: %v", err)
+	}
+
+	key := PublicKey{
+		Version:      header.Version,
+		KeyID:        header.KeyID,
+		CertifyingID: header.CertifyingID,
+		KeyUsage:     header.KeyUsage,
+		Reserved:     header.Reserved,
+		ExponentSize: header.ExponentSize,
+		ModulusSize:  header.ModulusSize,
+	}
+
+	exponentSize := int(header.ExponentSize / 8)
+	modulusSize := int(header.ModulusSize / 8)
+	size := key.Size()
+
+	if modulusSize == 0 || exponentSize == 0 || size > len(raw) {
+		return nil, fmt.Errorf("Invalid key sizes: 0x%X 0x%X", header.ExponentSize, header.ModulusSize)
+	}
+
+	key.Exponent = new(big.Int).SetBytes(reverse(raw[PublicKeyHeaderSize : PublicKeyHeaderSize+exponentSize]))
+	key.Modulus = new(big.Int).SetBytes(reverse(raw[PublicKeyHeaderSize+exponentSize : size]))
+
+	if len(raw) > size {
+		key.Signature = raw[size:]
+	}
+
+	return &key, nil
+}
+
+// Length of the key token without its signature
+func (key PublicKey) Size() int {
+	return PublicKeyHeaderSize + int(key.ExponentSize/8) + int(key.ModulusSize/8)
+}
+
+// Root keys certify themselves
+func (key PublicKey) IsRoot() bool {
+	return key.KeyID == key.CertifyingID
+}
+
+// Keys are equal if ID, exponent and modulus match
+func (key PublicKey) Equal(other *PublicKey) bool {
+	if other == nil || key.KeyID != other.KeyID {
+		return false
+	}
+	if key.Exponent == nil || key.Modulus == nil || other.Exponent == nil || other.Modulus == nil {
+		return false
+	}
+	return key.Exponent.Cmp(other.Exponent) == 0 && key.Modulus.Cmp(other.Modulus) == 0
+}
+
+func (key PublicKey) RSA() (*rsa.PublicKey, error) {
+	if key.Exponent == nil || key.Modulus == nil {
+		return nil, fmt.Errorf("Incomplete public key")
+	}
+	if !key.Exponent.IsInt64() || key.Exponent.Int64() > int64(^uint32(0)>>1) {
+		return nil, fmt.Errorf("Exponent to big")
+	}
+	return &rsa.PublicKey{
+		E: int(key.Exponent.Int64()),
+		N: key.Modulus,
+	}, nil
+}
+
+func reverse(s []byte) []byte {
+	reversed := make([]byte, len(s))
+	for i, v := range s {
+		reversed[len(s)-1-i] = v
+	}
+	return reversed
+}
@@ -0,0 +1,78 @@
+package amdfw
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"github.com/stretchr/testify/assert"
+	"math/big"
+	"testing"
+)
+
+func TestParsePublicKey(t *testing.T) {
+	rootKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+	oemKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+
+	root, err := ParsePublicKey(mockPublicKeyBytes(t, testRootKeyID, rootKey, testRootKeyID, nil))
+	assert.Nil(t, err)
+	assert.Equal(t, testRootKeyID, root.KeyID)
+	assert.Equal(t, uint32(2048), root.ModulusSize)
+	assert.Equal(t, 0, rootKey.N.Cmp(root.Modulus))
+	assert.Equal(t, int64(rootKey.E), root.Exponent.Int64())
+	assert.True(t, root.IsRoot())
+	assert.Nil(t, root.Signature)
+
+	oem, err := ParsePublicKey(mockPublicKeyBytes(t, testOEMKeyID, oemKey, testRootKeyID, rootKey))
+	assert.Nil(t, err)
+	assert.Equal(t, testRootKeyID, oem.CertifyingID)
+	assert.False(t, oem.IsRoot())
+	assert.Equal(t, 0x100, len(oem.Signature))
+
+	rsaKey, err := oem.RSA()
+	assert.Nil(t, err)
+	assert.Equal(t, oemKey.PublicKey, *rsaKey)
+}
+
+func TestParsePublicKeyToSmall(t *testing.T) {
+	raw := make([]byte, PublicKeyHeaderSize)
+	raw[0x38] = 0x00
+	raw[0x39] = 0x08
+	raw[0x3C] = 0x00
+	raw[0x3D] = 0x08
+
+	key, err := ParsePublicKey(raw)
+
+	assert.EqualError(t, err, "Invalid key sizes: 0x800 0x800")
+	assert.Nil(t, key)
+}
+
+func TestParseEntryPublicKey(t *testing.T) {
+	rootKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+	keyBytes := mockPublicKeyBytes(t, testRootKeyID, rootKey, testRootKeyID, nil)
+
+	imageBytes := make([]byte, testImage16MB)
+	copy(imageBytes[0xC1000:], keyBytes)
+	directoryEntry := DirectoryEntry{Type: 0x0, Size: uint32(len(keyBytes)), Location: 0xff0c1000}
+
+	entry, err := ParseEntry(imageBytes, directoryEntry, DefaultFlashMapping)
+
+	assert.Nil(t, err)
+	assert.Nil(t, entry.Header)
+	assert.NotNil(t, entry.PublicKey)
+	assert.Equal(t, testRootKeyID, entry.PublicKey.KeyID)
+	assert.Equal(t, "AMD_PUBLIC_KEY", entry.TypeInfo.Name)
+}
+
+func TestPublicKey_Equal(t *testing.T) {
+	key := PublicKey{KeyID: [0x10]byte{0x1}, Exponent: big.NewInt(0x10001), Modulus: big.NewInt(0x1234)}
+	same := PublicKey{KeyID: [0x10]byte{0x1}, Exponent: big.NewInt(0x10001), Modulus: big.NewInt(0x1234)}
+	otherModulus := PublicKey{KeyID: [0x10]byte{0x1}, Exponent: big.NewInt(0x10001), Modulus: big.NewInt(0x4321)}
+	otherID := PublicKey{KeyID: [0x10]byte{0x2}, Exponent: big.NewInt(0x10001), Modulus: big.NewInt(0x1234)}
+
+	assert.True(t, key.Equal(&same))
+	assert.False(t, key.Equal(&otherModulus))
+	assert.False(t, key.Equal(&otherID))
+	assert.False(t, key.Equal(nil))
+}
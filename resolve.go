@@ -0,0 +1,271 @@
+package amdfw
+
+import (
+	"fmt"
+	"strings"
+)
+
+type (
+	// IDs combo entries are matched against. Zero IDs are unknown and never match.
+	SoC struct {
+		PSPID        uint32
+		ChipFamilyID uint32
+	}
+
+	// Directories a specific processor boots from, in the order the PSP reads them.
+	// Combo directories passed on the way are included.
+	BootChain struct {
+		SoC             SoC
+		PSPDirectories  []*Directory
+		BIOSDirectories []*Directory
+	}
+)
+
+func (soc SoC) String() string {
+	switch {
+	case soc.ChipFamilyID == 0:
+		return fmt.Sprintf("SoC ID 0x%08X", soc.PSPID)
+	case soc.PSPID == 0:
+		return fmt.Sprintf("chip family 0x%08X", soc.ChipFamilyID)
+	default:
+		return fmt.Sprintf("SoC ID 0x%08X (chip family 0x%08X)", soc.PSPID, soc.ChipFamilyID)
+	}
+}
+
+// Whether the combo entry selects the directory for soc
+func (soc SoC) Matches(combo ComboEntry) bool {
+	var id uint32
+	switch combo.IDSelect {
+	case PSPIDSelect:
+		id = soc.PSPID
+	case ChipFamilyIDSelect:
+		id = soc.ChipFamilyID
+	}
+	return id != 0 && id == combo.SoCID
+}
+
+// Resolves the boot chain of the processor with PSP ID socID
+func (image *Image) ResolveFor(socID uint32) (*BootChain, error) {
+	return image.ResolveForSoC(SoC{PSPID: socID})
+}
+
+// Walks the FET, combo directories and level 2 pointers the way the PSP of soc would.
+// Newer PSP fields are tried first. BIOS fields set for other families are not guessed between.
+// Only directories already parsed into the Roms are considered.
+// Chains nested deeper than MaxDirectoryDepth are rejected.
+func (image *Image) ResolveForSoC(soc SoC) (*BootChain, error) {
+	if image.FET == nil {
+		return nil, fmt.Errorf("Cannot resolve %s: No FirmwareEntryTable", soc)
+	}
+
+	directories := make(map[uint32]*Directory)
+	var slots []*ImageSlot
+	for _, rom := range image.Roms {
+		for _, directory := range rom.Directories {
+			directories[directory.Location] = directory
+		}
+		slots = append(slots, rom.Slots...)
+	}
+
+	chain := BootChain{SoC: soc}
+	space := image.AddressSpace(nil)
+	maxDepth := image.MaxDirectoryDepth
+	if maxDepth <= 0 {
+		maxDepth = DefaultMaxDirectoryDepth
+	}
+
+	var err error
+	chain.PSPDirectories, err = resolveFirst(directories, slots, space, soc, maxDepth, image.FET.NewPSPDirBase, image.FET.PSPDirBase)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot resolve PSP directory for %s: %w", soc, err)
+	}
+
+	chain.BIOSDirectories, err = resolveBIOS(directories, slots, space, soc, maxDepth,
+		image.FET.BIOS3DirBase, image.FET.BIOS2DirBase, image.FET.NewBHDDirBase, image.FET.BHDDirBase)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot resolve BIOS directory for %s: %w", soc, err)
+	}
+
+	return &chain, nil
+}
+
+// Each family reads its own BIOS field of the FET. A field is only chosen for soc if its
+// combo directory lists soc or if no other field points to a different directory.
+func resolveBIOS(directories map[uint32]*Directory, slots []*ImageSlot, space AddressSpace, soc SoC, maxDepth int, bases ...*uint32) ([]*Directory, error) {
+	err := fmt.Errorf("No directory in FET")
+	var plain []uint32
+	for _, base := range bases {
+		if !isSetDirBase(base) {
+			continue
+		}
+
+		location := space.ToFlashOffset(*base)
+		if directory, found := directories[location]; found && directory.IsCombo() {
+			var chain []*Directory
+			chain, err = resolveChain(directories, slots, nil, location, soc, space, maxDepth)
+			if err == nil {
+				return chain, nil
+			}
+			continue
+		}
+		if !containsLocation(plain, location) {
+			plain = append(plain, location)
+		}
+	}
+
+	switch len(plain) {
+	case 0:
+		return nil, err
+	case 1:
+		return resolveChain(directories, slots, nil, plain[0], soc, space, maxDepth)
+	default:
+		locations := make([]string, len(plain))
+		for i, location := range plain {
+			locations[i] = fmt.Sprintf("0x%08X", location)
+		}
+		return nil, fmt.Errorf("Directories at %s are read by different families and none is a combo directory", strings.Join(locations, ", "))
+	}
+}
+
+func containsLocation(locations []uint32, location uint32) bool {
+	for _, known := range locations {
+		if known == location {
+			return true
+		}
+	}
+	return false
+}
+
+// Resolves the chain of the first base soc can boot from
+func resolveFirst(directories map[uint32]*Directory, slots []*ImageSlot, space AddressSpace, soc SoC, maxDepth int, bases ...*uint32) ([]*Directory, error) {
+	err := fmt.Errorf("No directory in FET")
+	for _, base := range bases {
+		if !isSetDirBase(base) {
+			continue
+		}
+
+		var chain []*Directory
+		chain, err = resolveChain(directories, slots, nil, space.ToFlashOffset(*base), soc, space, maxDepth)
+		if err == nil {
+			return chain, nil
+		}
+	}
+	return nil, err
+}
+
+// Resolves the chain starting at location. path holds the directories read before.
+func resolveChain(directories map[uint32]*Directory, slots []*ImageSlot, path []uint32, location uint32, soc SoC, space AddressSpace, maxDepth int) ([]*Directory, error) {
+	if err := checkDirectoryPath(path, location, maxDepth); err != nil {
+		return nil, err
+	}
+
+	directory, found := directories[location]
+	if !found {
+		return nil, fmt.Errorf("No directory at 0x%08X", location)
+	}
+
+	next, err := nextDirectories(directory, slots, soc, space)
+	if err != nil {
+		return nil, err
+	}
+	if len(next) == 0 {
+		return []*Directory{directory}, nil
+	}
+
+	// Candidates are tried in order, later ones only if the earlier cannot be used
+	path = append(path, location)
+	for _, location := range next {
+		var chain []*Directory
+		chain, err = resolveChain(directories, slots, path, location, soc, space, maxDepth)
+		if err == nil {
+			return append([]*Directory{directory}, chain...), nil
+		}
+	}
+	return nil, err
+}
+
+// Returns the directories the PSP may continue with after reading directory.
+// A/B recovery directories continue with the selected slot.
+func nextDirectories(directory *Directory, slots []*ImageSlot, soc SoC, space AddressSpace) ([]uint32, error) {
+	if directory.IsCombo() {
+		return nextComboDirectories(directory, soc, space)
+	}
+
+	var candidates []*ImageSlot
+	for _, slot := range slots {
+		if slot.Parent == directory {
+			candidates = append(candidates, slot)
+		}
+	}
+	if len(candidates) != 0 {
+		slot := SelectSlot(candidates)
+		if slot == nil {
+			return nil, fmt.Errorf("No bootable slot in %s directory at 0x%08X", string(directory.Header.Cookie[:]), directory.Location)
+		}
+		return []uint32{slot.Directory.Location}, nil
+	}
+
+	for i := range directory.Entries {
+		if isDirectoryType(directory.Entries[i].DirectoryEntry.Type) {
+			return []uint32{directory.ResolveLocation(&directory.Entries[i].DirectoryEntry, space)}, nil
+		}
+	}
+	return nil, nil
+}
+
+// With ID lookup the PSP uses the first matching entry only.
+// With dynamic lookup it falls back to the next match if a directory cannot be used.
+func nextComboDirectories(directory *Directory, soc SoC, space AddressSpace) ([]uint32, error) {
+	lookupMode := directory.ComboHeader().LookupMode
+	if lookupMode != DynamicComboLookup && lookupMode != IDComboLookup {
+		return nil, fmt.Errorf("Unknown lookup mode %s of %s directory at 0x%08X", lookupMode, string(directory.Header.Cookie[:]), directory.Location)
+	}
+
+	var next []uint32
+	for i, combo := range directory.ComboEntries() {
+		if !soc.Matches(combo) {
+			continue
+		}
+		next = append(next, directory.ResolveLocation(&directory.Entries[i].DirectoryEntry, space))
+		if lookupMode == IDComboLookup {
+			break
+		}
+	}
+	if len(next) == 0 {
+		return nil, fmt.Errorf("No entry for %s in %s directory at 0x%08X", soc, string(directory.Header.Cookie[:]), directory.Location)
+	}
+	return next, nil
+}
+
+// Entries of the chain as seen by the processor. Entries of a level 2 directory replace
+// entries of the same type of the level above. Combo, level 2 and slot pointer entries are omitted.
+func (chain *BootChain) PSPEntries() []Entry {
+	return effectiveEntries(chain.PSPDirectories)
+}
+
+func (chain *BootChain) BIOSEntries() []Entry {
+	return effectiveEntries(chain.BIOSDirectories)
+}
+
+func effectiveEntries(directories []*Directory) []Entry {
+	var entries []Entry
+	index := make(map[uint32]int)
+	for _, directory := range directories {
+		if directory.IsCombo() {
+			continue
+		}
+		for _, entry := range directory.Entries {
+			entryType := entry.DirectoryEntry.EncodedType()
+			if isDirectoryType(entry.DirectoryEntry.Type) || isSlotType(entry.DirectoryEntry.Type) {
+				continue
+			}
+			if i, found := index[entryType]; found {
+				entries[i] = entry
+				continue
+			}
+			index[entryType] = len(entries)
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
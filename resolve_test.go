@@ -0,0 +1,218 @@
+package amdfw
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func mockComboImage() *Image {
+	mapping := DefaultFlashMapping
+	pspCombo := uint32(0xFF020000)
+	bhd := uint32(0xFF030000)
+
+	newDirectory := func(cookie string, location uint32, entries ...DirectoryEntry) *Directory {
+		directory := Directory{Location: location, SlotBase: location}
+		copy(directory.Header.Cookie[:], cookie)
+		for _, entry := range entries {
+			directory.Entries = append(directory.Entries, Entry{DirectoryEntry: entry})
+		}
+		directory.Header.TotalEntries = uint32(len(directory.Entries))
+		return &directory
+	}
+
+	return &Image{
+		FET:          &FirmwareEntryTable{NewPSPDirBase: &pspCombo, BHDDirBase: &bhd},
+		FlashMapping: &mapping,
+		Roms: []*Rom{{
+			Type: NewPSPRom,
+			Directories: []*Directory{
+				newDirectory(DUALPSPCOOCKIE, 0x20000,
+					ComboEntry{SoCID: 0xBC0A0000, DirectoryPointer: 0xFF040000}.DirectoryEntry(),
+					ComboEntry{SoCID: 0xBC0B0500, DirectoryPointer: 0xFF050000}.DirectoryEntry(),
+				),
+				newDirectory(PSPCOOCKIE, 0x40000,
+					DirectoryEntry{Type: 0x1, Size: 0x100, Location: 0xFF041000},
+					DirectoryEntry{Type: 0x40, Size: 0x400, Location: 0xFF060000},
+				),
+				newDirectory(PSPCOOCKIE, 0x50000,
+					DirectoryEntry{Type: 0x1, Size: 0x100, Location: 0xFF051000},
+				),
+				newDirectory(SECONDPSPCOOCKIE, 0x60000,
+					DirectoryEntry{Type: 0x1, Size: 0x200, Location: 0xFF061000},
+					DirectoryEntry{Type: 0x8, Size: 0x100, Location: 0xFF062000},
+				),
+			},
+		}, {
+			Type: BHDRom,
+			Directories: []*Directory{
+				newDirectory(BHDCOOCKIE, 0x30000,
+					DirectoryEntry{Type: 0x60, Size: 0x100, Location: 0xFF031000},
+				),
+			},
+		}},
+	}
+}
+
+func TestImage_ResolveFor(t *testing.T) {
+	image := mockComboImage()
+
+	chain, err := image.ResolveFor(0xBC0A0000)
+
+	assert.Nil(t, err)
+	assert.Equal(t, SoC{PSPID: 0xBC0A0000}, chain.SoC)
+	assert.Equal(t, []*Directory{image.Roms[0].Directories[0], image.Roms[0].Directories[1], image.Roms[0].Directories[3]}, chain.PSPDirectories)
+	assert.Equal(t, []*Directory{image.Roms[1].Directories[0]}, chain.BIOSDirectories)
+
+	entries := chain.PSPEntries()
+	assert.Equal(t, 2, len(entries))
+	assert.Equal(t, uint32(0xFF061000), entries[0].DirectoryEntry.Location)
+	assert.Equal(t, uint32(0xFF062000), entries[1].DirectoryEntry.Location)
+	assert.Equal(t, 1, len(chain.BIOSEntries()))
+}
+
+func TestImage_ResolveForOtherSoC(t *testing.T) {
+	image := mockComboImage()
+
+	chain, err := image.ResolveFor(0xBC0B0500)
+
+	assert.Nil(t, err)
+	assert.Equal(t, []*Directory{image.Roms[0].Directories[0], image.Roms[0].Directories[2]}, chain.PSPDirectories)
+	assert.Equal(t, uint32(0xFF051000), chain.PSPEntries()[0].DirectoryEntry.Location)
+}
+
+func TestImage_ResolveForUnknownSoC(t *testing.T) {
+	image := mockComboImage()
+
+	chain, err := image.ResolveFor(0x12345678)
+
+	assert.Nil(t, chain)
+	assert.EqualError(t, err, "Cannot resolve PSP directory for SoC ID 0x12345678: No entry for SoC ID 0x12345678 in 2PSP directory at 0x00020000")
+}
+
+func TestImage_ResolveForLoop(t *testing.T) {
+	image := mockComboImage()
+	image.Roms[0].Directories[3].Entries[1].DirectoryEntry = DirectoryEntry{Type: 0x40, Size: 0x400, Location: 0xFF040000}
+
+	_, err := image.ResolveFor(0xBC0A0000)
+
+	assert.EqualError(t, err, "Cannot resolve PSP directory for SoC ID 0xBC0A0000: Directory at 0x00040000 is referenced in a loop: 0x00020000 -> 0x00040000 -> 0x00060000 -> 0x00040000")
+
+	var loop *DirectoryLoopError
+	assert.True(t, errors.As(err, &loop))
+	assert.Equal(t, []uint32{0x20000, 0x40000, 0x60000}, loop.Path)
+}
+
+// Adds a BIOS3 rom whose directory is plain or a 2BHD listing socID
+func mockBIOS3Image(combo bool, socID uint32) *Image {
+	image := mockComboImage()
+	bios2 := uint32(0)
+	bios3 := uint32(0xFF070000)
+	image.FET.BIOS2DirBase = &bios2
+	image.FET.BIOS3DirBase = &bios3
+
+	bhd := Directory{Location: 0x80000, SlotBase: 0x80000}
+	copy(bhd.Header.Cookie[:], BHDCOOCKIE)
+	bhd.Entries = []Entry{{DirectoryEntry: DirectoryEntry{Type: 0x60, Size: 0x100, Location: 0xFF081000}}}
+
+	bios3Directory := bhd
+	bios3Directory.Location = 0x70000
+	rom := Rom{Type: BIOS3Rom, Directories: []*Directory{&bios3Directory}}
+	if combo {
+		copy(bios3Directory.Header.Cookie[:], DUALBHDCOOCKIE)
+		bios3Directory.Entries = []Entry{{DirectoryEntry: ComboEntry{SoCID: socID, DirectoryPointer: 0xFF080000}.DirectoryEntry()}}
+		rom.Directories = append(rom.Directories, &bhd)
+	}
+	image.Roms = append(image.Roms, &rom)
+	return image
+}
+
+func TestImage_ResolveForBIOS3Plain(t *testing.T) {
+	image := mockBIOS3Image(false, 0)
+
+	// BHDDirBase and BIOS3DirBase are read by different families
+	_, err := image.ResolveFor(0xBC0A0000)
+
+	assert.EqualError(t, err, "Cannot resolve BIOS directory for SoC ID 0xBC0A0000: Directories at 0x00070000, 0x00030000 are read by different families and none is a combo directory")
+}
+
+func TestImage_ResolveForBIOS3Combo(t *testing.T) {
+	image := mockBIOS3Image(true, 0xBC0A0000)
+
+	chain, err := image.ResolveFor(0xBC0A0000)
+
+	assert.Nil(t, err)
+	assert.Equal(t, image.Roms[2].Directories, chain.BIOSDirectories)
+
+	// SoCs not listed in the combo directory read the only other field
+	chain, err = image.ResolveFor(0xBC0B0500)
+
+	assert.Nil(t, err)
+	assert.Equal(t, image.Roms[1].Directories, chain.BIOSDirectories)
+}
+
+func mockMixedComboImage(lookupMode ComboLookupMode) *Image {
+	image := mockComboImage()
+	combo := image.Roms[0].Directories[0]
+	combo.Header.Reserved = uint32(lookupMode)
+	combo.Entries = []Entry{
+		{DirectoryEntry: ComboEntry{IDSelect: ChipFamilyIDSelect, SoCID: 0xBC0A0000, DirectoryPointer: 0xFF050000}.DirectoryEntry()},
+		{DirectoryEntry: ComboEntry{IDSelect: ChipFamilyIDSelect, SoCID: 0x8A, DirectoryPointer: 0xFF080000}.DirectoryEntry()},
+		{DirectoryEntry: ComboEntry{IDSelect: PSPIDSelect, SoCID: 0xBC0A0000, DirectoryPointer: 0xFF040000}.DirectoryEntry()},
+	}
+	combo.Header.TotalEntries = uint32(len(combo.Entries))
+	return image
+}
+
+func TestImage_ResolveForSoCIDSelect(t *testing.T) {
+	image := mockMixedComboImage(IDComboLookup)
+
+	// The chip family entry with the same value as the PSP ID is not used
+	chain, err := image.ResolveFor(0xBC0A0000)
+
+	assert.Nil(t, err)
+	assert.Equal(t, []*Directory{image.Roms[0].Directories[0], image.Roms[0].Directories[1], image.Roms[0].Directories[3]}, chain.PSPDirectories)
+
+	chain, err = image.ResolveForSoC(SoC{ChipFamilyID: 0xBC0A0000})
+
+	assert.Nil(t, err)
+	assert.Equal(t, []*Directory{image.Roms[0].Directories[0], image.Roms[0].Directories[2]}, chain.PSPDirectories)
+}
+
+func TestImage_ResolveForSoCIDLookup(t *testing.T) {
+	image := mockMixedComboImage(IDComboLookup)
+
+	// The first match has no directory and ID lookup does not try further
+	_, err := image.ResolveForSoC(SoC{PSPID: 0xBC0A0000, ChipFamilyID: 0x8A})
+
+	assert.EqualError(t, err, "Cannot resolve PSP directory for SoC ID 0xBC0A0000 (chip family 0x0000008A): No directory at 0x00080000")
+}
+
+func TestImage_ResolveForSoCDynamicLookup(t *testing.T) {
+	image := mockMixedComboImage(DynamicComboLookup)
+
+	chain, err := image.ResolveForSoC(SoC{PSPID: 0xBC0A0000, ChipFamilyID: 0x8A})
+
+	assert.Nil(t, err)
+	assert.Equal(t, []*Directory{image.Roms[0].Directories[0], image.Roms[0].Directories[1], image.Roms[0].Directories[3]}, chain.PSPDirectories)
+}
+
+func TestImage_ResolveForSoCUnknownLookup(t *testing.T) {
+	image := mockMixedComboImage(2)
+
+	_, err := image.ResolveFor(0xBC0A0000)
+
+	assert.EqualError(t, err, "Cannot resolve PSP directory for SoC ID 0xBC0A0000: Unknown lookup mode unknown (2) of 2PSP directory at 0x00020000")
+}
+
+func TestImage_ResolveForMaxDepth(t *testing.T) {
+	image := mockComboImage()
+	image.MaxDirectoryDepth = 1
+
+	_, err := image.ResolveFor(0xBC0A0000)
+
+	assert.EqualError(t, err, "Cannot resolve PSP directory for SoC ID 0xBC0A0000: Directory at 0x00060000 exceeds the maximum depth of 1: 0x00020000 -> 0x00040000")
+
+	var depth *DirectoryDepthError
+	assert.True(t, errors.As(err, &depth))
+}
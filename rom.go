@@ -1,8 +1,8 @@
 package amdfw
 
 import (
-	"bytes"
 	"fmt"
+	"strings"
 )
 
 const (
@@ -13,6 +13,8 @@ const (
 	NewPSPRom RomType = "NEWPSP"
 	BHDRom    RomType = "BHD"
 	NewBHDRom RomType = "NEWBDH"
+	BIOS2Rom  RomType = "BIOS2"
+	BIOS3Rom  RomType = "BIOS3"
 )
 
 type (
@@ -22,10 +24,38 @@ type (
 		Type        RomType
 		Directories []*Directory
 		Raw         []byte
+		// A/B recovery slots referenced by the directories
+		Slots []*ImageSlot
+		// Pointers to directories reached before through another parent
+		SharedReferences []DirectoryReference
+	}
+
+	// Directory pointer from Parent to a directory of the same rom
+	DirectoryReference struct {
+		Parent    *Directory
+		Directory *Directory
+	}
+
+	// State of walking the directories of one rom. Every location is parsed once.
+	directoryWalk struct {
+		firmwareBytes []byte
+		space         AddressSpace
+		maxDepth      int
+		visited       map[uint32]*Directory
+		directories   []*Directory
+		shared        []DirectoryReference
 	}
 )
 
 func ParseRoms(firmwareBytes []byte, table *FirmwareEntryTable, flashMapping uint32) ([]*Rom, []error) {
+	return ParseRomsInSpace(firmwareBytes, table, NewAddressSpace(flashMapping, 0, firmwareBytes))
+}
+
+func ParseRomsInSpace(firmwareBytes []byte, table *FirmwareEntryTable, space AddressSpace) ([]*Rom, []error) {
+	return parseRoms(firmwareBytes, table, space, DefaultMaxDirectoryDepth)
+}
+
+func parseRoms(firmwareBytes []byte, table *FirmwareEntryTable, space AddressSpace, maxDepth int) ([]*Rom, []error) {
 	var roms []*Rom
 
 	var errors []error
@@ -35,61 +65,85 @@ func ParseRoms(firmwareBytes []byte, table *FirmwareEntryTable, flashMapping uin
 	//TODO XHCI
 
 	// PSP
-	rom, err := ParsePSPRom(firmwareBytes, table, flashMapping)
+	rom, err := parseDirectoryRom(firmwareBytes, table.PSPDirBase, PSPRom, space, maxDepth)
 	if err != nil {
-		errors = append(errors, fmt.Errorf("Could not parse psp rom: %v", err))
+		errors = append(errors, fmt.Errorf("Could not parse psp rom: %w", err))
 	}
 	if rom != nil {
 		roms = append(roms, rom)
 	}
 
 	// newPSP
-	rom, err = ParseNewPSPRom(firmwareBytes, table, flashMapping)
+	rom, err = parseDirectoryRom(firmwareBytes, table.NewPSPDirBase, NewPSPRom, space, maxDepth)
 	if err != nil {
-		errors = append(errors, fmt.Errorf("Could not parse newpsp rom: %v", err))
+		errors = append(errors, fmt.Errorf("Could not parse newpsp rom: %w", err))
 	}
 	if rom != nil {
 		roms = append(roms, rom)
 	}
 
 	// BHD
-	rom, err = ParseBHDRom(firmwareBytes, table, flashMapping)
+	rom, err = parseDirectoryRom(firmwareBytes, table.BHDDirBase, BHDRom, space, maxDepth)
 	if err != nil {
-		errors = append(errors, fmt.Errorf("Could not parse bhd rom: %v", err))
+		errors = append(errors, fmt.Errorf("Could not parse bhd rom: %w", err))
 	}
 	if rom != nil {
 		roms = append(roms, rom)
 	}
 
 	// newBHD
-	rom, err = ParseNewBHDRom(firmwareBytes, table, flashMapping)
+	rom, err = parseDirectoryRom(firmwareBytes, table.NewBHDDirBase, NewBHDRom, space, maxDepth)
 	if err != nil {
-		errors = append(errors, fmt.Errorf("Could not parse new bhd rom: %v", err))
+		errors = append(errors, fmt.Errorf("Could not parse new bhd rom: %w", err))
 	}
 	if rom != nil {
 		roms = append(roms, rom)
 	}
 
+	// BIOS2 and BIOS3 only exist on newer families and are usually unset
+	for _, bios := range []struct {
+		base    *uint32
+		romType RomType
+	}{
+		{table.BIOS2DirBase, BIOS2Rom},
+		{table.BIOS3DirBase, BIOS3Rom},
+	} {
+		if !isSetDirBase(bios.base) {
+			continue
+		}
+		rom, err = parseDirectoryRom(firmwareBytes, bios.base, bios.romType, space, maxDepth)
+		if err != nil {
+			errors = append(errors, fmt.Errorf("Could not parse %s rom: %w", strings.ToLower(string(bios.romType)), err))
+		}
+		if rom != nil {
+			roms = append(roms, rom)
+		}
+	}
+
 	return roms, errors
 }
 
+func isSetDirBase(base *uint32) bool {
+	return base != nil && *base != 0 && *base != ^uint32(0)
+}
+
 func ParsePSPRom(firmwareBytes []byte, table *FirmwareEntryTable, flashMapping uint32) (*Rom, error) {
-	return parseDirectoryRom(firmwareBytes, table.PSPDirBase, flashMapping, PSPRom)
+	return parseDirectoryRom(firmwareBytes, table.PSPDirBase, PSPRom, NewAddressSpace(flashMapping, 0, firmwareBytes), DefaultMaxDirectoryDepth)
 }
 
 func ParseNewPSPRom(firmwareBytes []byte, table *FirmwareEntryTable, flashMapping uint32) (*Rom, error) {
-	return parseDirectoryRom(firmwareBytes, table.NewPSPDirBase, flashMapping, NewPSPRom)
+	return parseDirectoryRom(firmwareBytes, table.NewPSPDirBase, NewPSPRom, NewAddressSpace(flashMapping, 0, firmwareBytes), DefaultMaxDirectoryDepth)
 }
 
 func ParseBHDRom(firmwareBytes []byte, table *FirmwareEntryTable, flashMapping uint32) (*Rom, error) {
-	return parseDirectoryRom(firmwareBytes, table.BHDDirBase, flashMapping, BHDRom)
+	return parseDirectoryRom(firmwareBytes, table.BHDDirBase, BHDRom, NewAddressSpace(flashMapping, 0, firmwareBytes), DefaultMaxDirectoryDepth)
 }
 
 func ParseNewBHDRom(firmwareBytes []byte, table *FirmwareEntryTable, flashMapping uint32) (*Rom, error) {
-	return parseDirectoryRom(firmwareBytes, table.NewBHDDirBase, flashMapping, NewBHDRom)
+	return parseDirectoryRom(firmwareBytes, table.NewBHDDirBase, NewBHDRom, NewAddressSpace(flashMapping, 0, firmwareBytes), DefaultMaxDirectoryDepth)
 }
 
-func parseDirectoryRom(firmwareBytes []byte, address *uint32, flashMapping uint32, romType RomType) (*Rom, error) {
+func parseDirectoryRom(firmwareBytes []byte, address *uint32, romType RomType, space AddressSpace, maxDepth int) (*Rom, error) {
 	rom := Rom{
 		Type: romType,
 	}
@@ -98,45 +152,83 @@ func parseDirectoryRom(firmwareBytes []byte, address *uint32, flashMapping uint3
 		return nil, fmt.Errorf("No %s offset available", romType)
 	}
 
-	directory, err := ParseDirectory(firmwareBytes, *address, flashMapping)
+	directory, err := ParseDirectoryInSpace(firmwareBytes, *address, space)
 
 	if err != nil {
 		return nil, fmt.Errorf("Could not read %s Rom: %v", romType, err)
 	}
 
-	rom.Directories = append(rom.Directories, directory)
-	others, err := recursiveDirectories(firmwareBytes, directory, flashMapping)
+	walk := newDirectoryWalk(firmwareBytes, directory, space, maxDepth)
+	err = walk.walk(directory, []uint32{directory.Location})
 
-	rom.Directories = append(rom.Directories, others...)
+	rom.Directories = append([]*Directory{directory}, walk.directories...)
+	rom.SharedReferences = walk.shared
+	rom.Slots = parseSlots(firmwareBytes, rom.Directories, space)
 	return &rom, err
 
 }
 
-func recursiveDirectories(firmwareBytes []byte, directory *Directory, flashMapping uint32) ([]*Directory, error) {
-	var directories []*Directory
-	for _, entry := range directory.Entries {
-		if entry.DirectoryEntry.Type == 0x40 ||
-			entry.DirectoryEntry.Type == 0x70 ||
-			bytes.Equal(directory.Header.Cookie[:], []byte(DUALPSPCOOCKIE)) {
+func recursiveDirectories(firmwareBytes []byte, directory *Directory, space AddressSpace) ([]*Directory, error) {
+	walk := newDirectoryWalk(firmwareBytes, directory, space, DefaultMaxDirectoryDepth)
+	err := walk.walk(directory, []uint32{directory.Location})
+	return walk.directories, err
+}
 
-			newDirectory, err := ParseDirectory(firmwareBytes, entry.DirectoryEntry.Location, flashMapping)
+func newDirectoryWalk(firmwareBytes []byte, root *Directory, space AddressSpace, maxDepth int) *directoryWalk {
+	return &directoryWalk{
+		firmwareBytes: firmwareBytes,
+		space:         space,
+		maxDepth:      maxDepth,
+		visited:       map[uint32]*Directory{root.Location: root},
+	}
+}
 
-			if err != nil {
-				return directories, fmt.Errorf("Could not read Directory: %v", err)
+// Follows all directory pointers of directory. path holds the locations of directory and its parents.
+// Directories already visited through another parent are recorded as shared reference and not walked again.
+func (walk *directoryWalk) walk(directory *Directory, path []uint32) error {
+	for i := range directory.Entries {
+		entry := &directory.Entries[i]
+		if isDirectoryType(entry.DirectoryEntry.Type) || directory.IsCombo() || isSlotType(entry.DirectoryEntry.Type) {
+
+			location := directory.ResolveLocation(&entry.DirectoryEntry, walk.space)
+			// Slots start with their Image Slot Header, or the directory if there is none
+			slotBase := location
+			if !directory.IsCombo() && isSlotType(entry.DirectoryEntry.Type) {
+				var err error
+				if location, _, err = parseSlotTarget(walk.firmwareBytes, location, walk.space); err != nil {
+					return fmt.Errorf("Could not read Directory: %v", err)
+				}
 			}
 
-			directories = append(directories, newDirectory)
-			others, err := recursiveDirectories(firmwareBytes, newDirectory, flashMapping)
+			if err := checkDirectoryPath(path, location, walk.maxDepth); err != nil {
+				return err
+			}
+
+			if visited, found := walk.visited[location]; found {
+				walk.shared = append(walk.shared, DirectoryReference{Parent: directory, Directory: visited})
+				continue
+			}
+
+			newDirectory, err := parseDirectoryInSlot(walk.firmwareBytes, location, slotBase, walk.space)
+
 			if err != nil {
-				return directories, fmt.Errorf("Could not read Directory: %v", err)
+				return fmt.Errorf("Could not read Directory: %v", err)
+			}
+
+			walk.visited[location] = newDirectory
+			walk.directories = append(walk.directories, newDirectory)
+			if err := walk.walk(newDirectory, append(path[:len(path):len(path)], location)); err != nil {
+				return err
 			}
-			directories = append(directories, others...)
 		}
 	}
-	return directories, nil
+	return nil
 }
 
 func GetAddressFromTable(romType RomType, table *FirmwareEntryTable) (uint32, error) {
+	if table == nil {
+		return 0, fmt.Errorf("Cannot get Address: No FirmwareEntryTable")
+	}
 	switch romType {
 	case PSPRom:
 		return *table.PSPDirBase, nil
@@ -146,6 +238,10 @@ func GetAddressFromTable(romType RomType, table *FirmwareEntryTable) (uint32, er
 		return *table.BHDDirBase, nil
 	case NewBHDRom:
 		return *table.NewBHDDirBase, nil
+	case BIOS2Rom:
+		return *table.BIOS2DirBase, nil
+	case BIOS3Rom:
+		return *table.BIOS3DirBase, nil
 	case GECRom:
 		return *table.GecRomBase, nil
 	case IMCRom:
@@ -159,6 +255,10 @@ func GetAddressFromTable(romType RomType, table *FirmwareEntryTable) (uint32, er
 }
 
 func (rom Rom) Write(baseImage []byte, table *FirmwareEntryTable, flashMapping uint32) error {
+	return rom.WriteInSpace(baseImage, table, NewAddressSpace(flashMapping, 0, baseImage))
+}
+
+func (rom Rom) WriteInSpace(baseImage []byte, table *FirmwareEntryTable, space AddressSpace) error {
 	var err error
 	if rom.Raw != nil {
 		address, err := GetAddressFromTable(rom.Type, table)
@@ -167,20 +267,37 @@ func (rom Rom) Write(baseImage []byte, table *FirmwareEntryTable, flashMapping u
 			return fmt.Errorf("Cannot Write: Unknown Type")
 		}
 
-		address = address &^ flashMapping
-
-		if int(address)+len(rom.Raw) > len(baseImage) {
+		if !space.Contains(address, uint32(len(rom.Raw))) {
 			return fmt.Errorf("Cannot write Rom: Invalid address in FET")
 		}
 
-		copy(baseImage[address:], rom.Raw)
+		relative, _ := space.ToRelative(address)
+		copy(baseImage[relative:], rom.Raw)
 	} else if rom.Directories != nil {
+		// Refuse before any directory is written
+		for _, directory := range rom.Directories {
+			if err = directory.checkEntries(baseImage, space); err != nil {
+				return fmt.Errorf("Cannot Write Rom: %v", err)
+			}
+		}
 		for _, directory := range rom.Directories {
-			err = directory.Write(baseImage, flashMapping)
+			err = directory.WriteInSpace(baseImage, space)
 			if err != nil {
 				return fmt.Errorf("Cannot Write Rom: %v", err)
 			}
 		}
+		for _, slot := range rom.Slots {
+			if slot.Header == nil {
+				continue
+			}
+			relative, inImage := space.ToRelative(slot.HeaderLocation)
+			if !inImage {
+				return fmt.Errorf("Cannot Write Rom: Slot %s is not part of the image", slot.Name)
+			}
+			if err = slot.Header.Write(baseImage, relative); err != nil {
+				return fmt.Errorf("Cannot Write Rom: %v", err)
+			}
+		}
 	}
 	return nil
 }
@@ -92,10 +92,11 @@ func TestParseRomsRecursiv(t *testing.T) {
 		copy(imageBytes[entry.DirectoryEntry.Location&^DefaultFlashMapping:], testPSPMiniDirectoryBytes)
 	}
 
-	directories, err := recursiveDirectories(imageBytes, &test2PSPDirectory, DefaultFlashMapping)
+	directories, err := recursiveDirectories(imageBytes, &test2PSPDirectory, NewAddressSpace(DefaultFlashMapping, 0, imageBytes))
 
+	// Two SoC IDs share the directory at 0xff1dd000
 	assert.Nil(t, err)
-	assert.Equal(t, len(directories), 4)
+	assert.Equal(t, len(directories), 3)
 
 	for _, dir := range directories {
 		assert.Equal(t, testPSPMiniDirectory.Header, dir.Header)
@@ -104,3 +105,79 @@ func TestParseRomsRecursiv(t *testing.T) {
 		}
 	}
 }
+
+func TestParseRomsRecursivBaseType(t *testing.T) {
+	imageBytes := make([]byte, 0x100000)
+	for _, directory := range []Directory{
+		{Location: 0x10000, Header: DirectoryHeader{Cookie: [4]byte{'$', 'P', 'S', 'P'}}},
+		{Location: 0x20000, Header: DirectoryHeader{Cookie: [4]byte{'$', 'P', 'L', '2'}}},
+	} {
+		if directory.Location == 0x10000 {
+			// Level 2 pointer with subprogram and rom ID bits set
+			assert.Nil(t, directory.AddEntry(Entry{DirectoryEntry: DirectoryEntry{Type: 0x10140, Size: 0x400, Location: 0x20000}}))
+		}
+		assert.Nil(t, directory.Write(imageBytes, 0))
+	}
+	base := uint32(0x10000)
+
+	rom, err := ParsePSPRom(imageBytes, &FirmwareEntryTable{PSPDirBase: &base}, 0)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(rom.Directories))
+	assert.Equal(t, uint32(0x20000), rom.Directories[1].Location)
+}
+
+func TestParseRomsBIOS3(t *testing.T) {
+	imageBytes := make([]byte, 0x100000)
+	directory := Directory{Location: 0x30000, Header: DirectoryHeader{Cookie: [4]byte{'$', 'B', 'H', 'D'}}}
+	assert.Nil(t, directory.Write(imageBytes, 0))
+	unset := uint32(0xFFFFFFFF)
+	base := uint32(0x30000)
+
+	roms, _ := parseRoms(imageBytes, &FirmwareEntryTable{BIOS2DirBase: &unset, BIOS3DirBase: &base}, NewAddressSpace(0, 0, imageBytes), DefaultMaxDirectoryDepth)
+
+	assert.Equal(t, 1, len(roms))
+	assert.Equal(t, BIOS3Rom, roms[0].Type)
+	assert.Equal(t, uint32(0x30000), roms[0].Directories[0].Location)
+}
+
+func TestParseRomsRecursivShared(t *testing.T) {
+	imageBytes := make([]byte, testImage16MB)
+	copy(imageBytes[testPSPDirBase-DefaultFlashMapping:], test2PSPDirectoryBytes)
+	for _, entry := range test2PSPDirectory.Entries {
+		copy(imageBytes[entry.DirectoryEntry.Location&^DefaultFlashMapping:], testPSPMiniDirectoryBytes)
+	}
+	base := testPSPDirBase
+
+	rom, err := ParsePSPRom(imageBytes, &FirmwareEntryTable{PSPDirBase: &base}, DefaultFlashMapping)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 4, len(rom.Directories))
+	assert.Equal(t, []DirectoryReference{{Parent: rom.Directories[0], Directory: rom.Directories[2]}}, rom.SharedReferences)
+	assert.Equal(t, uint32(0x1dd000), rom.Directories[2].Location)
+}
+
+func TestParseRomsFanOut(t *testing.T) {
+	imageBytes := make([]byte, 0x100000)
+	levels := 7
+	for level := 0; level < levels; level++ {
+		location := uint32(0x10000 * (level + 1))
+		directory := Directory{Location: location, Header: DirectoryHeader{Cookie: [4]byte{'$', 'P', 'S', 'P'}}}
+		if level != levels-1 {
+			// Every pointer of a level leads to the same directory
+			directory.Header.Cookie = [4]byte{'2', 'P', 'S', 'P'}
+			for id := uint32(1); id <= 3; id++ {
+				directory.Entries = append(directory.Entries, Entry{DirectoryEntry: ComboEntry{SoCID: id, DirectoryPointer: uint64(location + 0x10000)}.DirectoryEntry()})
+			}
+			directory.Header.TotalEntries = uint32(len(directory.Entries))
+		}
+		assert.Nil(t, directory.Write(imageBytes, 0))
+	}
+	base := uint32(0x10000)
+
+	rom, err := ParsePSPRom(imageBytes, &FirmwareEntryTable{PSPDirBase: &base}, 0)
+
+	assert.Nil(t, err)
+	assert.Equal(t, levels, len(rom.Directories))
+	assert.Equal(t, 2*(levels-1), len(rom.SharedReferences))
+}
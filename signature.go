@@ -0,0 +1,209 @@
+package amdfw
+
+import (
+	"crypto"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"fmt"
+)
+
+const (
+	SignatureValid      SignatureStatus = "valid"
+	SignatureInvalid    SignatureStatus = "invalid"
+	SignatureKeyMissing SignatureStatus = "key missing"
+	SignatureUnsigned   SignatureStatus = "unsigned"
+)
+
+type (
+	SignatureStatus string
+
+	SignatureVerdict struct {
+		Rom       *Rom
+		Directory *Directory
+		Entry     *Entry
+		Status    SignatureStatus
+		Error     error
+	}
+)
+
+// Verifies the signature of every entry against the AMD key chain.
+// The chain starts at the self certified AMD root key of type 0x00, which is only trusted
+// if it matches one of anchors, e.g. the root key of a known good image.
+// Other keys are only trusted once their certificate verifies against a trusted key.
+func (image *Image) VerifySignatures(anchors ...*PublicKey) ([]SignatureVerdict, error) {
+	var tokens []*Entry
+	var rootKey *PublicKey
+
+	for _, rom := range image.Roms {
+		for _, directory := range rom.Directories {
+			for i := range directory.Entries {
+				entry := &directory.Entries[i]
+				key := entry.PublicKey
+				if key == nil {
+					continue
+				}
+				if rootKey == nil && entry.DirectoryEntry.BaseType() == 0x00 && key.IsRoot() {
+					rootKey = key
+					continue
+				}
+				tokens = append(tokens, entry)
+			}
+		}
+	}
+
+	// An image signed with a replaced root key must not verify
+	anchored := false
+	for _, anchor := range anchors {
+		if rootKey != nil && rootKey.Equal(anchor) {
+			anchored = true
+		}
+	}
+
+	trusted := make(map[[0x10]byte]*PublicKey)
+	trustedRoot := rootKey
+	if anchored {
+		trusted[rootKey.KeyID] = rootKey
+	} else {
+		trustedRoot = nil
+	}
+
+	// Keys may be certified by keys found later in the image
+	for added := true; added; {
+		added = false
+		for _, token := range tokens {
+			// The first certified key of an ID wins, later tokens cannot replace it
+			if _, found := trusted[token.PublicKey.KeyID]; found {
+				continue
+			}
+			if status, _ := verifyKey(token, trusted); status == SignatureValid {
+				trusted[token.PublicKey.KeyID] = token.PublicKey
+				added = true
+			}
+		}
+	}
+
+	var verdicts []SignatureVerdict
+	for _, rom := range image.Roms {
+		for _, directory := range rom.Directories {
+			for i := range directory.Entries {
+				entry := &directory.Entries[i]
+				status, err := verifyEntry(entry, trustedRoot, trusted)
+				verdicts = append(verdicts, SignatureVerdict{
+					Rom:       rom,
+					Directory: directory,
+					Entry:     entry,
+					Status:    status,
+					Error:     err,
+				})
+			}
+		}
+	}
+
+	if rootKey == nil {
+		return verdicts, fmt.Errorf("No AMD root key found")
+	}
+	if !anchored {
+		return verdicts, fmt.Errorf("AMD root key %X does not match a trust anchor", rootKey.KeyID)
+	}
+	return verdicts, nil
+}
+
+// Verifies the certificate of a key token against the trusted keys
+func verifyKey(entry *Entry, trusted map[[0x10]byte]*PublicKey) (SignatureStatus, error) {
+	key := entry.PublicKey
+	if key.IsRoot() {
+		return SignatureKeyMissing, fmt.Errorf("Key %X is self certified but not the AMD root key", key.KeyID)
+	}
+	if len(key.Signature) == 0 {
+		return SignatureUnsigned, nil
+	}
+
+	signer, found := trusted[key.CertifyingID]
+	if !found {
+		return SignatureKeyMissing, fmt.Errorf("Certifying key %X not found", key.CertifyingID)
+	}
+	return verifySigned(signer, entry.Raw[:key.Size()], key.Signature)
+}
+
+func verifyEntry(entry *Entry, rootKey *PublicKey, trusted map[[0x10]byte]*PublicKey) (SignatureStatus, error) {
+	if key := entry.PublicKey; key != nil {
+		// Level 2 directories carry their own copy of the root key
+		if key.Equal(rootKey) {
+			return SignatureUnsigned, nil
+		}
+		return verifyKey(entry, trusted)
+	}
+
+	if entry.Header == nil || entry.Header.IsSigned == 0 {
+		return SignatureUnsigned, nil
+	}
+
+	signer, found := trusted[entry.Header.SigFingerprint]
+	if !found {
+		return SignatureKeyMissing, fmt.Errorf("Signing key %X not found", entry.Header.SigFingerprint)
+	}
+
+	signed, err := entry.signedBytes()
+	if err != nil {
+		return SignatureInvalid, err
+	}
+
+	signatureSize := int(signer.ModulusSize / 8)
+	if len(entry.Raw) < signatureSize {
+		return SignatureInvalid, fmt.Errorf("Entry to small for signature")
+	}
+
+	return verifySigned(signer, signed, entry.Raw[len(entry.Raw)-signatureSize:])
+}
+
+// Returns the header followed by the (decompressed) signed body
+func (entry Entry) signedBytes() ([]byte, error) {
+	if len(entry.Raw) < EntryHeaderSize {
+		return nil, fmt.Errorf("Entry to small for header")
+	}
+
+	body, err := entry.Payload()
+	if err != nil {
+		return nil, fmt.Errorf("Could not read signed body: %v", err)
+	}
+
+	if int(entry.Header.SizeSigned) > len(body) {
+		return nil, fmt.Errorf("Signed size 0x%X exceeds body", entry.Header.SizeSigned)
+	}
+
+	signed := make([]byte, 0, EntryHeaderSize+int(entry.Header.SizeSigned))
+	signed = append(signed, entry.Raw[:EntryHeaderSize]...)
+	signed = append(signed, body[:entry.Header.SizeSigned]...)
+	return signed, nil
+}
+
+// AMD uses RSASSA-PSS with SHA256 for 2048 bit keys and SHA384 for 4096 bit keys.
+// Signatures are stored little endian.
+func verifySigned(signer *PublicKey, signed []byte, signature []byte) (SignatureStatus, error) {
+	key, err := signer.RSA()
+	if err != nil {
+		return SignatureInvalid, err
+	}
+
+	signatureSize := key.Size()
+	if len(signature) < signatureSize {
+		return SignatureInvalid, fmt.Errorf("Signature to short: 0x%X bytes", len(signature))
+	}
+
+	hash := crypto.SHA256
+	if key.N.BitLen() > 2048 {
+		hash = crypto.SHA384
+	}
+
+	hasher := hash.New()
+	hasher.Write(signed)
+
+	err = rsa.VerifyPSS(key, hash, hasher.Sum(nil), reverse(signature[:signatureSize]), &rsa.PSSOptions{
+		SaltLength: rsa.PSSSaltLengthEqualsHash,
+	})
+	if err != nil {
+		return SignatureInvalid, err
+	}
+	return SignatureValid, nil
+}
@@ -0,0 +1,288 @@
+package amdfw
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/binary"
+	"github.com/stretchr/testify/assert"
+	"math/big"
+	"testing"
+)
+
+var (
+	testRootKeyID = [0x10]byte{0x1b, 0xb9, 0x87, 0xc3, 0x59, 0x49, 0x46, 0x06, 0xb1, 0x74, 0x94, 0x56, 0x01, 0xc9, 0xea, 0x5b}
+	testOEMKeyID  = [0x10]byte{0x27, 0x93, 0x94, 0xca, 0xc2, 0xc2, 0x4e, 0xfb, 0x97, 0xae, 0xda, 0x24, 0x1d, 0x31, 0xc8, 0xde}
+)
+
+func leBytes(value *big.Int, size int) []byte {
+	buf := make([]byte, size)
+	valueBytes := value.Bytes()
+	copy(buf[size-len(valueBytes):], valueBytes)
+	return reverse(buf)
+}
+
+func signPSS(t *testing.T, key *rsa.PrivateKey, data []byte) []byte {
+	digest := sha256.Sum256(data)
+	signature, err := rsa.SignPSS(rand.Reader, key, crypto.SHA256, digest[:], &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+	assert.Nil(t, err)
+	return reverse(signature)
+}
+
+func mockPublicKeyBytes(t *testing.T, id [0x10]byte, key *rsa.PrivateKey, certifyingID [0x10]byte, signer *rsa.PrivateKey) []byte {
+	size := key.Size()
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.LittleEndian, binaryPublicKeyHeader{
+		Version:      1,
+		KeyID:        id,
+		CertifyingID: certifyingID,
+		ExponentSize: uint32(size * 8),
+		ModulusSize:  uint32(size * 8),
+	})
+	buf.Write(leBytes(big.NewInt(int64(key.E)), size))
+	buf.Write(leBytes(key.N, size))
+
+	if signer != nil {
+		buf.Write(signPSS(t, signer, buf.Bytes()))
+	}
+	return buf.Bytes()
+}
+
+func mockSignedEntryBytes(t *testing.T, body []byte, keyID [0x10]byte, signer *rsa.PrivateKey) []byte {
+	header := EntryHeader{
+		ID:             0x52414230,
+		SizeSigned:     uint32(len(body)),
+		IsSigned:       1,
+		SigFingerprint: keyID,
+		SizePacked:     uint32(EntryHeaderSize + len(body) + signer.Size()),
+	}
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.LittleEndian, header)
+	buf.Write(body)
+	buf.Write(signPSS(t, signer, buf.Bytes()))
+	return buf.Bytes()
+}
+
+func mockSignedImage(t *testing.T) *Image {
+	rootKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+	oemKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+
+	entryBytes := mockSignedEntryBytes(t, bytes.Repeat([]byte{0x42}, 0x400), testOEMKeyID, oemKey)
+	entryHeader := EntryHeader{}
+	binary.Read(bytes.NewReader(entryBytes), binary.LittleEndian, &entryHeader)
+
+	rootKeyBytes := mockPublicKeyBytes(t, testRootKeyID, rootKey, testRootKeyID, nil)
+	rootPublicKey, err := ParsePublicKey(rootKeyBytes)
+	assert.Nil(t, err)
+	oemKeyBytes := mockPublicKeyBytes(t, testOEMKeyID, oemKey, testRootKeyID, rootKey)
+	oemPublicKey, err := ParsePublicKey(oemKeyBytes)
+	assert.Nil(t, err)
+
+	entries := []Entry{
+		{DirectoryEntry: DirectoryEntry{Type: 0x00}, Raw: rootKeyBytes, PublicKey: rootPublicKey},
+		{DirectoryEntry: DirectoryEntry{Type: 0x0A}, Raw: oemKeyBytes, PublicKey: oemPublicKey},
+		{DirectoryEntry: DirectoryEntry{Type: 0x01}, Raw: entryBytes, Header: &entryHeader},
+		{DirectoryEntry: DirectoryEntry{Type: 0x0B, Size: 0xFFFFFFFF, Location: 0x1}},
+	}
+
+	image := Image{
+		Roms: []*Rom{{
+			Type:        PSPRom,
+			Directories: []*Directory{{Entries: entries}},
+		}},
+	}
+	return &image
+}
+
+// Parses a separate copy of the root key of image
+func mockAnchor(t *testing.T, image *Image) *PublicKey {
+	anchor, err := ParsePublicKey(image.Roms[0].Directories[0].Entries[0].Raw)
+	assert.Nil(t, err)
+	return anchor
+}
+
+func TestImage_VerifySignatures(t *testing.T) {
+	image := mockSignedImage(t)
+	anchor := mockAnchor(t, image)
+
+	verdicts, err := image.VerifySignatures(anchor)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 4, len(verdicts))
+	assert.Equal(t, SignatureUnsigned, verdicts[0].Status)
+	assert.Equal(t, SignatureValid, verdicts[1].Status)
+	assert.Equal(t, SignatureValid, verdicts[2].Status)
+	assert.Equal(t, SignatureUnsigned, verdicts[3].Status)
+	assert.Equal(t, &image.Roms[0].Directories[0].Entries[2], verdicts[2].Entry)
+}
+
+func TestImage_VerifySignaturesInvalid(t *testing.T) {
+	image := mockSignedImage(t)
+	anchor := mockAnchor(t, image)
+	image.Roms[0].Directories[0].Entries[2].Raw[EntryHeaderSize] ^= 0xFF
+
+	verdicts, err := image.VerifySignatures(anchor)
+
+	assert.Nil(t, err)
+	assert.Equal(t, SignatureValid, verdicts[1].Status)
+	assert.Equal(t, SignatureInvalid, verdicts[2].Status)
+	assert.NotNil(t, verdicts[2].Error)
+}
+
+func TestImage_VerifySignaturesInvalidCertificate(t *testing.T) {
+	image := mockSignedImage(t)
+	anchor := mockAnchor(t, image)
+	image.Roms[0].Directories[0].Entries[1].PublicKey.Signature[0] ^= 0xFF
+
+	verdicts, err := image.VerifySignatures(anchor)
+
+	// Entries signed by a key without valid certificate are not trusted
+	assert.Nil(t, err)
+	assert.Equal(t, SignatureInvalid, verdicts[1].Status)
+	assert.Equal(t, SignatureKeyMissing, verdicts[2].Status)
+}
+
+func TestImage_VerifySignaturesKeyMissing(t *testing.T) {
+	image := mockSignedImage(t)
+	anchor := mockAnchor(t, image)
+	image.Roms[0].Directories[0].Entries = image.Roms[0].Directories[0].Entries[1:]
+
+	verdicts, err := image.VerifySignatures(anchor)
+
+	assert.EqualError(t, err, "No AMD root key found")
+	assert.Equal(t, SignatureKeyMissing, verdicts[0].Status)
+	assert.Equal(t, SignatureKeyMissing, verdicts[1].Status)
+}
+
+func TestImage_VerifySignaturesSelfCertified(t *testing.T) {
+	image := mockSignedImage(t)
+	anchor := mockAnchor(t, image)
+	attackerKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+	attackerID := [0x10]byte{0xAA}
+
+	keyBytes := mockPublicKeyBytes(t, attackerID, attackerKey, attackerID, nil)
+	publicKey, err := ParsePublicKey(keyBytes)
+	assert.Nil(t, err)
+	entryBytes := mockSignedEntryBytes(t, bytes.Repeat([]byte{0x42}, 0x400), attackerID, attackerKey)
+	entryHeader := EntryHeader{}
+	binary.Read(bytes.NewReader(entryBytes), binary.LittleEndian, &entryHeader)
+
+	directory := image.Roms[0].Directories[0]
+	directory.Entries = append(directory.Entries,
+		Entry{DirectoryEntry: DirectoryEntry{Type: 0x0A}, Raw: keyBytes, PublicKey: publicKey},
+		Entry{DirectoryEntry: DirectoryEntry{Type: 0x01}, Raw: entryBytes, Header: &entryHeader},
+	)
+
+	verdicts, err := image.VerifySignatures(anchor)
+
+	assert.Nil(t, err)
+	assert.Equal(t, SignatureValid, verdicts[2].Status)
+	assert.Equal(t, SignatureKeyMissing, verdicts[4].Status)
+	assert.Equal(t, SignatureKeyMissing, verdicts[5].Status)
+}
+
+func TestImage_VerifySignaturesUnsignedToken(t *testing.T) {
+	image := mockSignedImage(t)
+	anchor := mockAnchor(t, image)
+	attackerKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+	attackerID := [0x10]byte{0xAA}
+
+	keyBytes := mockPublicKeyBytes(t, attackerID, attackerKey, testRootKeyID, nil)
+	publicKey, err := ParsePublicKey(keyBytes)
+	assert.Nil(t, err)
+	entryBytes := mockSignedEntryBytes(t, bytes.Repeat([]byte{0x42}, 0x400), attackerID, attackerKey)
+	entryHeader := EntryHeader{}
+	binary.Read(bytes.NewReader(entryBytes), binary.LittleEndian, &entryHeader)
+
+	directory := image.Roms[0].Directories[0]
+	directory.Entries = append(directory.Entries,
+		Entry{DirectoryEntry: DirectoryEntry{Type: 0x0A}, Raw: keyBytes, PublicKey: publicKey},
+		Entry{DirectoryEntry: DirectoryEntry{Type: 0x01}, Raw: entryBytes, Header: &entryHeader},
+	)
+
+	verdicts, err := image.VerifySignatures(anchor)
+
+	assert.Nil(t, err)
+	assert.Equal(t, SignatureUnsigned, verdicts[4].Status)
+	assert.Equal(t, SignatureKeyMissing, verdicts[5].Status)
+}
+
+func TestImage_VerifySignaturesDuplicateKeyID(t *testing.T) {
+	image := mockSignedImage(t)
+	anchor := mockAnchor(t, image)
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+
+	// A later token reusing the OEM key ID must not replace the certified key
+	keyBytes := mockPublicKeyBytes(t, testOEMKeyID, otherKey, testOEMKeyID, nil)
+	publicKey, err := ParsePublicKey(keyBytes)
+	assert.Nil(t, err)
+
+	directory := image.Roms[0].Directories[0]
+	directory.Entries = append(directory.Entries, Entry{DirectoryEntry: DirectoryEntry{Type: 0x0A}, Raw: keyBytes, PublicKey: publicKey})
+
+	verdicts, err := image.VerifySignatures(anchor)
+
+	assert.Nil(t, err)
+	assert.Equal(t, SignatureValid, verdicts[2].Status)
+	assert.Equal(t, SignatureKeyMissing, verdicts[4].Status)
+}
+
+func TestImage_VerifySignaturesRootBaseType(t *testing.T) {
+	image := mockSignedImage(t)
+	anchor := mockAnchor(t, image)
+	image.Roms[0].Directories[0].Entries[0].DirectoryEntry.Type = 0x10000
+
+	verdicts, err := image.VerifySignatures(anchor)
+
+	assert.Nil(t, err)
+	assert.Equal(t, SignatureUnsigned, verdicts[0].Status)
+	assert.Equal(t, SignatureValid, verdicts[2].Status)
+}
+
+func TestImage_VerifySignaturesReplacedRoot(t *testing.T) {
+	anchor := mockAnchor(t, mockSignedImage(t))
+	// A second image with its own root key of the same ID, signing everything itself
+	image := mockSignedImage(t)
+
+	verdicts, err := image.VerifySignatures(anchor)
+
+	assert.EqualError(t, err, "AMD root key 1BB987C359494606B174945601C9EA5B does not match a trust anchor")
+	assert.Equal(t, SignatureKeyMissing, verdicts[0].Status)
+	assert.Equal(t, SignatureKeyMissing, verdicts[1].Status)
+	assert.Equal(t, SignatureKeyMissing, verdicts[2].Status)
+}
+
+func TestImage_VerifySignaturesNoAnchor(t *testing.T) {
+	image := mockSignedImage(t)
+
+	verdicts, err := image.VerifySignatures()
+
+	assert.EqualError(t, err, "AMD root key 1BB987C359494606B174945601C9EA5B does not match a trust anchor")
+	assert.Equal(t, SignatureKeyMissing, verdicts[2].Status)
+}
+
+func TestImage_VerifySignaturesRootKeyCopy(t *testing.T) {
+	image := mockSignedImage(t)
+	anchor := mockAnchor(t, image)
+	rootKeyBytes := image.Roms[0].Directories[0].Entries[0].Raw
+	copied, err := ParsePublicKey(rootKeyBytes)
+	assert.Nil(t, err)
+	image.Roms[0].Directories = append(image.Roms[0].Directories, &Directory{
+		Entries: []Entry{{DirectoryEntry: DirectoryEntry{Type: 0x00}, Raw: rootKeyBytes, PublicKey: copied}},
+	})
+
+	verdicts, err := image.VerifySignatures(anchor)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 5, len(verdicts))
+	assert.Equal(t, SignatureUnsigned, verdicts[0].Status)
+	assert.Equal(t, SignatureUnsigned, verdicts[4].Status)
+	assert.Nil(t, verdicts[4].Error)
+}
@@ -0,0 +1,185 @@
+package amdfw
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// PSP directory entries pointing to the Image Slot Header of a recovery slot
+const (
+	slotAEntryType = 0x48
+	slotBEntryType = 0x4A
+)
+
+type (
+	// Precedes the level 2 directory of an A/B recovery slot
+	ImageSlotHeader struct {
+		Checksum         uint32
+		BootPriority     uint32
+		UpdateRetryCount uint32
+		GlitchRetryCount uint8
+		Reserved0D       [3]uint8
+		// Level 2 directory of the slot
+		Location    uint32
+		PSPID       uint32
+		SlotMaxSize uint32
+		Reserved1C  uint32
+	}
+
+	ImageSlot struct {
+		Name string
+		// nil if the entry points to the directory directly
+		Header *ImageSlotHeader
+		// Flash offset of the Image Slot Header
+		HeaderLocation uint32
+		// Directory containing the slot entry
+		Parent    *Directory
+		Directory *Directory
+	}
+)
+
+func isSlotType(entryType uint32) bool {
+	baseType := uint8(entryType)
+	return baseType == slotAEntryType || baseType == slotBEntryType
+}
+
+func ParseImageSlotHeader(firmwareBytes []byte, address uint32, space AddressSpace) (*ImageSlotHeader, error) {
+	header := ImageSlotHeader{}
+	headerBytes, err := space.Slice(firmwareBytes, address, uint32(binary.Size(header)))
+	if err != nil {
+		return nil, fmt.Errorf("Could not read Image Slot Header: %v", err)
+	}
+
+	if err := binary.Read(bytes.NewReader(headerBytes), binary.LittleEndian, &header); err != nil {
+		return nil, fmt.Errorf("Could not read Image Slot Header: %v", err)
+	}
+	return &header, nil
+}
+
+// Resolves the level 2 directory a slot entry points to.
+// Older A/B images point to the directory directly instead of an Image Slot Header.
+func parseSlotTarget(firmwareBytes []byte, address uint32, space AddressSpace) (uint32, *ImageSlotHeader, error) {
+	cookie, err := space.Slice(firmwareBytes, address, 4)
+	if err != nil {
+		return 0, nil, fmt.Errorf("Could not read slot: %v", err)
+	}
+	for _, c := range directoryCookies {
+		if string(cookie) == c {
+			return space.ToFlashOffset(address), nil, nil
+		}
+	}
+
+	header, err := ParseImageSlotHeader(firmwareBytes, address, space)
+	if err != nil {
+		return 0, nil, err
+	}
+	return space.ToFlashOffset(header.Location), header, nil
+}
+
+// Collects the slots referenced by the directories of a rom
+func parseSlots(firmwareBytes []byte, directories []*Directory, space AddressSpace) []*ImageSlot {
+	byLocation := make(map[uint32]*Directory)
+	for _, directory := range directories {
+		byLocation[directory.Location] = directory
+	}
+
+	var slots []*ImageSlot
+	for _, directory := range directories {
+		for i := range directory.Entries {
+			entry := &directory.Entries[i].DirectoryEntry
+			if directory.IsCombo() || !isSlotType(entry.Type) {
+				continue
+			}
+
+			location := directory.ResolveLocation(entry, space)
+			target, header, err := parseSlotTarget(firmwareBytes, location, space)
+			if err != nil {
+				continue
+			}
+
+			name := "A"
+			if entry.BaseType() == slotBEntryType {
+				name = "B"
+			}
+			slots = append(slots, &ImageSlot{
+				Name:           name,
+				Header:         header,
+				HeaderLocation: location,
+				Parent:         directory,
+				Directory:      byLocation[target],
+			})
+		}
+	}
+	return slots
+}
+
+// Fletcher-32 over everything following the checksum field
+func (header *ImageSlotHeader) CalculateChecksum() uint32 {
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.LittleEndian, header)
+	return fletcher32(buf.Bytes()[4:])
+}
+
+func (header *ImageSlotHeader) ValidateChecksum() (valid bool, actual uint32) {
+	sum := header.CalculateChecksum()
+	return sum == header.Checksum, sum
+}
+
+func (header *ImageSlotHeader) UpdateChecksum() {
+	header.Checksum = header.CalculateChecksum()
+}
+
+func (header *ImageSlotHeader) Write(baseImage []byte, address uint32) error {
+	buf := new(bytes.Buffer)
+
+	if int(address)+binary.Size(header) > len(baseImage) {
+		return fmt.Errorf("Writing ImageSlotHeader failed: BaseImage to small")
+	}
+
+	if err := binary.Write(buf, binary.LittleEndian, header); err != nil {
+		return fmt.Errorf("Writing binary failed: %v", err)
+	}
+
+	copy(baseImage[address:], buf.Bytes())
+	return nil
+}
+
+// Whether the PSP would try to boot from the slot
+func (slot *ImageSlot) IsBootable() bool {
+	if slot.Directory == nil {
+		return false
+	}
+	if slot.Header == nil {
+		return true
+	}
+	valid, _ := slot.Header.ValidateChecksum()
+	return valid && slot.Header.UpdateRetryCount != 0
+}
+
+func (slot *ImageSlot) priority() uint32 {
+	if slot.Header == nil {
+		return 0
+	}
+	return slot.Header.BootPriority
+}
+
+// Returns the bootable slot with the highest priority, A wins ties.
+// nil if no slot is bootable.
+func SelectSlot(slots []*ImageSlot) *ImageSlot {
+	var selected *ImageSlot
+	for _, slot := range slots {
+		if !slot.IsBootable() {
+			continue
+		}
+		if selected == nil || slot.priority() > selected.priority() {
+			selected = slot
+		}
+	}
+	return selected
+}
+
+// Slot the PSP would boot from
+func (rom *Rom) ActiveSlot() *ImageSlot {
+	return SelectSlot(rom.Slots)
+}
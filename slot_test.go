@@ -0,0 +1,204 @@
+package amdfw
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func mockSlotImage(t *testing.T, priorityA uint32, priorityB uint32) []byte {
+	imageBytes := make([]byte, 0x100000)
+
+	writeDirectory := func(cookie string, location uint32, entries ...DirectoryEntry) {
+		directory := Directory{Location: location}
+		copy(directory.Header.Cookie[:], cookie)
+		for _, entry := range entries {
+			assert.Nil(t, directory.AddEntry(Entry{DirectoryEntry: entry}))
+		}
+		assert.Nil(t, directory.Write(imageBytes, 0))
+	}
+
+	writeDirectory(PSPCOOCKIE, 0x10000,
+		DirectoryEntry{Type: slotAEntryType, Size: 0x20, Location: 0x20000},
+		DirectoryEntry{Type: slotBEntryType, Size: 0x20, Location: 0x21000},
+	)
+	writeDirectory(SECONDPSPCOOCKIE, 0x30000, DirectoryEntry{Type: 0x1, Size: 0x10, Location: 0x31000})
+	writeDirectory(SECONDPSPCOOCKIE, 0x40000, DirectoryEntry{Type: 0x1, Size: 0x10, Location: 0x41000})
+
+	for _, slot := range []struct {
+		address  uint32
+		priority uint32
+		target   uint32
+	}{
+		{0x20000, priorityA, 0x30000},
+		{0x21000, priorityB, 0x40000},
+	} {
+		header := ImageSlotHeader{BootPriority: slot.priority, UpdateRetryCount: 3, Location: slot.target, PSPID: 0xBC0A0000, SlotMaxSize: 0x10000}
+		header.UpdateChecksum()
+		assert.Nil(t, header.Write(imageBytes, slot.address))
+	}
+	return imageBytes
+}
+
+func TestParsePSPRomSlots(t *testing.T) {
+	imageBytes := mockSlotImage(t, 1, 2)
+	base := uint32(0x10000)
+
+	rom, err := ParsePSPRom(imageBytes, &FirmwareEntryTable{PSPDirBase: &base}, 0)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(rom.Directories))
+	assert.Equal(t, 2, len(rom.Slots))
+
+	slotA := rom.Slots[0]
+	assert.Equal(t, "A", slotA.Name)
+	assert.Equal(t, uint32(0x20000), slotA.HeaderLocation)
+	assert.Equal(t, rom.Directories[0], slotA.Parent)
+	assert.Equal(t, uint32(0x30000), slotA.Directory.Location)
+	assert.Equal(t, uint32(1), slotA.Header.BootPriority)
+	assert.Equal(t, uint32(0xBC0A0000), slotA.Header.PSPID)
+	valid, _ := slotA.Header.ValidateChecksum()
+	assert.True(t, valid)
+
+	assert.Equal(t, "B", rom.Slots[1].Name)
+	assert.Equal(t, uint32(0x40000), rom.Slots[1].Directory.Location)
+	assert.Equal(t, rom.Slots[1], rom.ActiveSlot())
+}
+
+func TestParsePSPRomSlotsWithoutHeader(t *testing.T) {
+	imageBytes := make([]byte, 0x100000)
+	for _, directory := range []Directory{
+		{Location: 0x10000, Header: DirectoryHeader{Cookie: [4]byte{'$', 'P', 'S', 'P'}}},
+		{Location: 0x30000, Header: DirectoryHeader{Cookie: [4]byte{'$', 'P', 'L', '2'}}},
+	} {
+		if directory.Location == 0x10000 {
+			assert.Nil(t, directory.AddEntry(Entry{DirectoryEntry: DirectoryEntry{Type: slotAEntryType, Size: 0x400, Location: 0x30000}}))
+		}
+		assert.Nil(t, directory.Write(imageBytes, 0))
+	}
+	base := uint32(0x10000)
+
+	rom, err := ParsePSPRom(imageBytes, &FirmwareEntryTable{PSPDirBase: &base}, 0)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(rom.Slots))
+	assert.Nil(t, rom.Slots[0].Header)
+	assert.Equal(t, rom.Directories[1], rom.Slots[0].Directory)
+	assert.Equal(t, rom.Slots[0], rom.ActiveSlot())
+}
+
+func TestSelectSlot(t *testing.T) {
+	directory := &Directory{}
+	newSlot := func(name string, priority uint32, retries uint32) *ImageSlot {
+		header := ImageSlotHeader{BootPriority: priority, UpdateRetryCount: retries}
+		header.UpdateChecksum()
+		return &ImageSlot{Name: name, Header: &header, Directory: directory}
+	}
+
+	slotA := newSlot("A", 1, 1)
+	slotB := newSlot("B", 1, 1)
+	assert.Equal(t, slotA, SelectSlot([]*ImageSlot{slotA, slotB}))
+
+	slotB.Header.BootPriority = 2
+	assert.Equal(t, slotA, SelectSlot([]*ImageSlot{slotA, slotB}))
+	slotB.Header.UpdateChecksum()
+	assert.Equal(t, slotB, SelectSlot([]*ImageSlot{slotA, slotB}))
+
+	// Exhausted retries make a slot unbootable
+	slotB.Header.UpdateRetryCount = 0
+	slotB.Header.UpdateChecksum()
+	assert.Equal(t, slotA, SelectSlot([]*ImageSlot{slotA, slotB}))
+
+	slotA.Directory = nil
+	assert.Nil(t, SelectSlot([]*ImageSlot{slotA, slotB}))
+}
+
+func TestImage_WriteSlotHeader(t *testing.T) {
+	imageBytes := mockSlotImage(t, 2, 1)
+	base := uint32(0x10000)
+	rom, err := ParsePSPRom(imageBytes, &FirmwareEntryTable{PSPDirBase: &base}, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, "A", rom.ActiveSlot().Name)
+
+	// The checksum is refreshed on write
+	rom.Slots[0].Header.UpdateRetryCount = 0
+	mapping := uint32(0)
+	image := Image{FlashMapping: &mapping, Roms: []*Rom{rom}}
+	_, err = image.Write(imageBytes)
+	assert.Nil(t, err)
+
+	rom, err = ParsePSPRom(imageBytes, &FirmwareEntryTable{PSPDirBase: &base}, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(0), rom.Slots[0].Header.UpdateRetryCount)
+	assert.Equal(t, "B", rom.ActiveSlot().Name)
+}
+
+func TestImage_ResolveForSlot(t *testing.T) {
+	imageBytes := mockSlotImage(t, 1, 2)
+	base := uint32(0x10000)
+	mapping := uint32(0)
+	rom, err := ParsePSPRom(imageBytes, &FirmwareEntryTable{PSPDirBase: &base}, 0)
+	assert.Nil(t, err)
+	image := Image{FET: &FirmwareEntryTable{PSPDirBase: &base}, FlashMapping: &mapping, Roms: []*Rom{rom}}
+
+	_, err = image.ResolveFor(0xBC0A0000)
+	assert.EqualError(t, err, "Cannot resolve BIOS directory for SoC ID 0xBC0A0000: No directory in FET")
+
+	bhd := Directory{Location: 0x50000, Header: DirectoryHeader{Cookie: [4]byte{'$', 'B', 'H', 'D'}}}
+	image.Roms = append(image.Roms, &Rom{Type: BHDRom, Directories: []*Directory{&bhd}})
+	image.FET.BHDDirBase = &bhd.Location
+
+	chain, err := image.ResolveFor(0xBC0A0000)
+
+	assert.Nil(t, err)
+	assert.Equal(t, []*Directory{rom.Directories[0], rom.Slots[1].Directory}, chain.PSPDirectories)
+	assert.Equal(t, uint32(0x41000), chain.PSPEntries()[0].DirectoryEntry.Location)
+}
+
+func TestIsSlotTypeBaseType(t *testing.T) {
+	assert.True(t, isSlotType(0x48))
+	assert.True(t, isSlotType(0x1004A))
+	assert.False(t, isSlotType(0x149))
+	assert.True(t, isDirectoryType(0x20070))
+}
+
+func TestParsePSPRomSlotRelative(t *testing.T) {
+	imageBytes := mockSlotImage(t, 1, 2)
+	copy(imageBytes[0x30000:], bytes.Repeat([]byte{0xFF}, 0x100))
+	slotDirectory := Directory{Location: 0x30000, Header: DirectoryHeader{Cookie: [4]byte{'$', 'P', 'L', '2'}}}
+	assert.Nil(t, slotDirectory.AddEntry(Entry{DirectoryEntry: DirectoryEntry{Type: 0x1, Size: 0x10, Location: 0x2000, Reserved: uint32(SlotRelativeAddressMode) << 30}}))
+	assert.Nil(t, slotDirectory.AddEntry(Entry{DirectoryEntry: DirectoryEntry{Type: 0x8, Size: 0x10, Location: 0x2000, Reserved: uint32(DirectoryRelativeAddressMode) << 30}}))
+	assert.Nil(t, slotDirectory.Write(imageBytes, 0))
+	base := uint32(0x10000)
+
+	rom, err := ParsePSPRom(imageBytes, &FirmwareEntryTable{PSPDirBase: &base}, 0)
+
+	// Slot relative locations are based on the Image Slot Header at 0x20000
+	assert.Nil(t, err)
+	directory := rom.Slots[0].Directory
+	assert.Equal(t, uint32(0x20000), directory.SlotBase)
+	space := NewAddressSpace(0, 0, imageBytes)
+	assert.Equal(t, uint32(0x22000), directory.ResolveLocation(&directory.Entries[0].DirectoryEntry, space))
+	assert.Equal(t, uint32(0x32000), directory.ResolveLocation(&directory.Entries[1].DirectoryEntry, space))
+
+	// Outside of slots the directory is the base
+	assert.Equal(t, uint32(0x10000), rom.Directories[0].SlotBase)
+}
+
+func TestImage_WriteSlotHeaderKeepChecksums(t *testing.T) {
+	imageBytes := mockSlotImage(t, 2, 1)
+	base := uint32(0x10000)
+	rom, err := ParsePSPRom(imageBytes, &FirmwareEntryTable{PSPDirBase: &base}, 0)
+	assert.Nil(t, err)
+
+	rom.Slots[0].Header.BootPriority = 0
+	mapping := uint32(0)
+	image := Image{FlashMapping: &mapping, Roms: []*Rom{rom}}
+	_, err = image.WriteWithOptions(imageBytes, WriteOptions{KeepChecksums: true})
+	assert.Nil(t, err)
+
+	rom, err = ParsePSPRom(imageBytes, &FirmwareEntryTable{PSPDirBase: &base}, 0)
+	assert.Nil(t, err)
+	valid, _ := rom.Slots[0].Header.ValidateChecksum()
+	assert.False(t, valid)
+}
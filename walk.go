@@ -0,0 +1,55 @@
+package amdfw
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Real images nest at most combo, level 1 and level 2 directories
+const DefaultMaxDirectoryDepth = 8
+
+type (
+	// A directory pointer leads back to a directory on its own path
+	DirectoryLoopError struct {
+		// Flash offsets of the directories from the root to the one containing the pointer
+		Path []uint32
+		// Flash offset the pointer leads to
+		Location uint32
+	}
+
+	// Directories are nested deeper than allowed
+	DirectoryDepthError struct {
+		Path     []uint32
+		Location uint32
+		MaxDepth int
+	}
+)
+
+func (err *DirectoryLoopError) Error() string {
+	return fmt.Sprintf("Directory at 0x%08X is referenced in a loop: %s", err.Location, formatDirectoryPath(append(err.Path[:len(err.Path):len(err.Path)], err.Location)))
+}
+
+func (err *DirectoryDepthError) Error() string {
+	return fmt.Sprintf("Directory at 0x%08X exceeds the maximum depth of %d: %s", err.Location, err.MaxDepth, formatDirectoryPath(err.Path))
+}
+
+func formatDirectoryPath(path []uint32) string {
+	parts := make([]string, len(path))
+	for i, location := range path {
+		parts[i] = fmt.Sprintf("0x%08X", location)
+	}
+	return strings.Join(parts, " -> ")
+}
+
+// Checks whether the directory at location may be followed from the end of path
+func checkDirectoryPath(path []uint32, location uint32, maxDepth int) error {
+	for _, visited := range path {
+		if visited == location {
+			return &DirectoryLoopError{Path: append([]uint32{}, path...), Location: location}
+		}
+	}
+	if len(path) > maxDepth {
+		return &DirectoryDepthError{Path: append([]uint32{}, path...), Location: location, MaxDepth: maxDepth}
+	}
+	return nil
+}
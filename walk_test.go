@@ -0,0 +1,81 @@
+package amdfw
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// Writes a chain of directories, each pointing to the next location
+func mockDirectoryChain(t *testing.T, locations []uint32, last uint32) []byte {
+	imageBytes := make([]byte, 0x100000)
+	for i, location := range locations {
+		next := last
+		if i+1 < len(locations) {
+			next = locations[i+1]
+		}
+
+		directory := Directory{Location: location}
+		copy(directory.Header.Cookie[:], PSPCOOCKIE)
+		if i != 0 {
+			copy(directory.Header.Cookie[:], SECONDPSPCOOCKIE)
+		}
+		assert.Nil(t, directory.AddEntry(Entry{DirectoryEntry: DirectoryEntry{Type: 0x40, Size: 0x400, Location: next}}))
+		assert.Nil(t, directory.Write(imageBytes, 0))
+	}
+	return imageBytes
+}
+
+func TestParsePSPRomLoop(t *testing.T) {
+	imageBytes := mockDirectoryChain(t, []uint32{0x10000, 0x20000}, 0x10000)
+	base := uint32(0x10000)
+
+	rom, err := ParsePSPRom(imageBytes, &FirmwareEntryTable{PSPDirBase: &base}, 0)
+
+	assert.EqualError(t, err, "Directory at 0x00010000 is referenced in a loop: 0x00010000 -> 0x00020000 -> 0x00010000")
+	var loop *DirectoryLoopError
+	assert.True(t, errors.As(err, &loop))
+	assert.Equal(t, []uint32{0x10000, 0x20000}, loop.Path)
+	assert.Equal(t, uint32(0x10000), loop.Location)
+
+	assert.NotNil(t, rom)
+	assert.Equal(t, 2, len(rom.Directories))
+}
+
+func TestParsePSPRomSelfReference(t *testing.T) {
+	imageBytes := mockDirectoryChain(t, []uint32{0x10000}, 0x10000)
+	base := uint32(0x10000)
+
+	rom, err := ParsePSPRom(imageBytes, &FirmwareEntryTable{PSPDirBase: &base}, 0)
+
+	var loop *DirectoryLoopError
+	assert.True(t, errors.As(err, &loop))
+	assert.Equal(t, []uint32{0x10000}, loop.Path)
+	assert.Equal(t, 1, len(rom.Directories))
+}
+
+func TestParseRomsMaxDepth(t *testing.T) {
+	locations := []uint32{0x10000, 0x20000, 0x30000, 0x40000}
+	imageBytes := mockDirectoryChain(t, locations, 0x50000)
+	base := uint32(0x10000)
+	space := NewAddressSpace(0, 0, imageBytes)
+
+	rom, err := parseDirectoryRom(imageBytes, &base, PSPRom, space, 2)
+
+	assert.EqualError(t, err, "Directory at 0x00040000 exceeds the maximum depth of 2: 0x00010000 -> 0x00020000 -> 0x00030000")
+	var depth *DirectoryDepthError
+	assert.True(t, errors.As(err, &depth))
+	assert.Equal(t, 2, depth.MaxDepth)
+	assert.Equal(t, 3, len(rom.Directories))
+
+	// Within the default depth the missing directory at the end of the chain is reported instead
+	roms, errs := parseRoms(imageBytes, &FirmwareEntryTable{PSPDirBase: &base}, space, DefaultMaxDirectoryDepth)
+	assert.Equal(t, 4, len(roms[0].Directories))
+	assert.False(t, errors.As(errs[0], &depth))
+	assert.Contains(t, errs[0].Error(), "No Valid Cookie")
+}
+
+func TestParseOptions_MaxDirectoryDepth(t *testing.T) {
+	assert.Equal(t, DefaultMaxDirectoryDepth, ParseOptions{}.maxDirectoryDepth())
+	assert.Equal(t, 3, ParseOptions{MaxDirectoryDepth: 3}.maxDirectoryDepth())
+}